@@ -20,20 +20,27 @@ func example() {
 
 	mainSession, err := mgo.Dial(dbhost)
 	if err != nil {
-		fmt.Errorf("Unable to connect to mongo server on %s", dbhost)
+		fmt.Printf("Unable to connect to mongo server on %s\n", dbhost)
+		return
 	}
 
 	defer mainSession.Close()
 
-	testURL, err := url.Parse("http://127.0.0.1/test?$top=10&$select=name,age&$orderby=time asc,name desc,age")
+	testURL, err := url.Parse("http://127.0.0.1/test?$top=10&$select=name,age&$orderby=time asc,name desc,age&$inlinecount=allpages")
 	if err != nil {
-		fmt.Errorf("failed to parse test url")
+		fmt.Printf("failed to parse test url\n")
+		return
 	}
 
 	var object []interface{}
 	collection := mainSession.DB("testdb").C("collectionName")
 
-	if err := odata.ODataQuery(testURL.Query(), &object, collection); err != nil {
-		fmt.Errorf("Error: %s", err.Error())
+	result, err := odata.ODataQueryResult(testURL.Query(), &object, collection)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	if result.HasInlineCount {
+		fmt.Printf("%d records in total\n", result.InlineCount)
 	}
 }
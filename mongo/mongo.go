@@ -7,10 +7,10 @@
 package mongo
 
 import (
-	"encoding/hex"
 	"net/url"
-	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -21,199 +21,271 @@ import (
 // ErrInvalidInput Client errors
 var ErrInvalidInput = errors.New("odata syntax error")
 
-// Making FilterObj global for now, so inlinecount can reuse it.
-// TODO: Refactor odata library API
-var filterObj bson.M
+var mongoOperators = map[string]string{
+	"eq": "$eq",
+	"ne": "$ne",
+	"gt": "$gt",
+	"ge": "$gte",
+	"lt": "$lt",
+	"le": "$lte",
+}
 
-// ODataQuery creates a mgo query based on odata parameters
-//nolint :gocyclo
-func ODataQuery(query url.Values, object interface{}, collection *mgo.Collection) error {
+// Filter of the last ODataQuery, kept for the deprecated ODataInlineCount
+var lastFilter = struct {
+	sync.Mutex
+	filter bson.M
+}{filter: bson.M{}}
+
+// Result holds the outcome of an odata query. Each query gets its own result,
+// so concurrent queries do not share any state.
+type Result struct {
+	// Filter is the mgo query document the records were selected with
+	Filter bson.M
+	// Items is the object the records were unmarshalled into
+	Items interface{}
+	// InlineCount is the number of records matching the filter, ignoring $top and $skip
+	InlineCount int
+	// HasInlineCount reports whether $inlinecount=allpages was requested
+	HasInlineCount bool
+}
 
-	// Parse url values
-	queryMap, err := parser.ParseURLValues(query)
+// ODataQuery creates a mgo query based on odata parameters
+func ODataQuery(query url.Values, object interface{}, collection *mgo.Collection, opts ...Option) error {
+	result, err := ODataQueryResult(query, object, collection, opts...)
 	if err != nil {
-		return errors.Wrap(ErrInvalidInput, err.Error())
+		return err
 	}
 
-	limit, _ := queryMap[parser.Top].(int)
-	skip, _ := queryMap[parser.Skip].(int)
+	lastFilter.Lock()
+	lastFilter.filter = result.Filter
+	lastFilter.Unlock()
+	return nil
+}
+
+// ODataQueryResult runs a mgo query based on odata parameters and unmarshals the records
+// into object. The result carries the filter of this query and the inline count when
+// $inlinecount=allpages is set.
+func ODataQueryResult(query url.Values, object interface{}, collection *mgo.Collection, opts ...Option) (*Result, error) {
 
-	filterObj = make(bson.M)
-	if queryMap[parser.Filter] != nil {
-		filterQuery, _ := queryMap[parser.Filter].(*parser.ParseNode)
-		var err error
-		filterObj, err = applyFilter(filterQuery)
-		if err != nil {
-			return errors.Wrap(ErrInvalidInput, err.Error())
-		}
+	o := newOptions(opts)
+	t, err := o.translate(query)
+	if err != nil {
+		return nil, err
 	}
 
-	// Prepare Select
-	selectMap := make(bson.M)
+	// Query
+	err = o.find(t, collection).All(object)
+	if err != nil {
+		return nil, err
+	}
 
-	if queryMap["$select"] != nil {
-		selectSlice := reflect.ValueOf(queryMap["$select"])
-		if selectSlice.Len() > 1 && selectSlice.Index(0).Interface().(string) != "*" {
-			for i := 0; i < selectSlice.Len(); i++ {
-				fieldName := selectSlice.Index(i).Interface().(string)
-				selectMap[fieldName] = 1
-			}
+	result := &Result{Filter: t.Filter, Items: object, HasInlineCount: t.InlineCount}
+	if t.InlineCount {
+		if result.InlineCount, err = collection.Find(t.Filter).Count(); err != nil {
+			return nil, err
 		}
 	}
+	return result, nil
+}
 
-	// Sort
-	var sortFields []string
-	if queryMap[parser.OrderBy] != nil {
-		orderBySlice := queryMap[parser.OrderBy].([]parser.OrderItem)
-		for _, item := range orderBySlice {
-			if item.Order == "desc" {
-				item.Field = "-" + item.Field
-			}
-			sortFields = append(sortFields, item.Field)
-		}
+// ODataIter runs a mgo query based on odata parameters and returns an iterator over the
+// records, so large result sets can be streamed instead of loaded into memory.
+// The caller must Close the iterator. Use WithBatchSize to control the size of the batches
+// fetched from the server.
+func ODataIter(query url.Values, collection *mgo.Collection, opts ...Option) (*mgo.Iter, error) {
+
+	o := newOptions(opts)
+	t, err := o.translate(query)
+	if err != nil {
+		return nil, err
 	}
+	return o.find(t, collection).Iter(), nil
+}
 
-	// Query
-	odataFunc := collection.Find(filterObj).Select(selectMap).Limit(limit).Skip(skip).Sort(sortFields...).All(object)
+// ODataForEach runs a mgo query based on odata parameters and calls fn for every record,
+// one batch in memory at a time. Iteration stops at the first error returned by fn.
+func ODataForEach(query url.Values, collection *mgo.Collection, fn func(raw bson.Raw) error, opts ...Option) error {
+	iter, err := ODataIter(query, collection, opts...)
+	if err != nil {
+		return err
+	}
 
-	return odataFunc
+	var raw bson.Raw
+	for iter.Next(&raw) {
+		if err := fn(raw); err != nil {
+			_ = iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
 }
 
-// ODataCount runs a collection.Count() function based on $count odata parameter
+// ODataCount runs a collection.Count() function based on $count odata parameter,
+// ignoring any filter. Use ODataFilteredCount for $count requests with a $filter.
 func ODataCount(collection *mgo.Collection) (int, error) {
 	return collection.Count()
 }
 
-// ODataInlineCount retrieves the total count from a filtered data
+// ODataFilteredCount counts the documents matching the $filter of the query,
+// constrained by the scope and policy options. $top and $skip are ignored.
+func ODataFilteredCount(query url.Values, collection *mgo.Collection, opts ...Option) (int, error) {
+
+	t, err := newOptions(opts).translate(query)
+	if err != nil {
+		return 0, err
+	}
+	return collection.Find(t.Filter).Count()
+}
+
+// ODataInlineCount retrieves the total count from the filter of the last ODataQuery
+//
+// Deprecated: concurrent queries replace each other's filter, use the InlineCount
+// of ODataQueryResult instead.
 func ODataInlineCount(collection *mgo.Collection) (int, error) {
+	lastFilter.Lock()
+	filter := lastFilter.filter
+	lastFilter.Unlock()
 
-	return collection.Find(filterObj).Count()
+	return collection.Find(filter).Count()
+}
+
+// FilterQuery translates a filter tree, parsed from $filter or built with the
+// parser package, into a mgo query document
+func FilterQuery(node *parser.ParseNode, opts ...Option) (bson.M, error) {
+	if err := parser.Validate(node); err != nil {
+		return nil, errors.Wrap(ErrInvalidInput, err.Error())
+	}
+	filter, err := applyFilter(parser.Normalize(node), newOptions(opts))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidInput, err.Error())
+	}
+	return filter, nil
 }
 
 //nolint :gocyclo
-func applyFilter(node *parser.ParseNode) (bson.M, error) {
+func applyFilter(node *parser.ParseNode, o *options) (bson.M, error) {
 
 	filter := make(bson.M)
 
+	// filters folded into a constant match everything or nothing
+	if value, ok := node.Token.Value.(bool); ok && len(node.Children) == 0 {
+		if !value {
+			filter["_id"] = bson.M{"$in": []interface{}{}}
+		}
+		return filter, nil
+	}
+
 	if _, ok := node.Token.Value.(string); ok {
 		switch node.Token.Value {
 
-		case "eq":
-			// Escape single quotes in the case of strings
-			if _, valueOk := node.Children[1].Token.Value.(string); valueOk {
-				node.Children[1].Token.Value = strings.Replace(node.Children[1].Token.Value.(string), "'", "", -1)
-			}
-			value := bson.M{"$" + node.Token.Value.(string): node.Children[1].Token.Value}
-			if _, keyOk := node.Children[0].Token.Value.(string); !keyOk {
-				return nil, ErrInvalidInput
-			}
-			filter[node.Children[0].Token.Value.(string)] = value
-
-		case "ne":
-			// Escape single quotes in the case of strings
-			if _, valueOk := node.Children[1].Token.Value.(string); valueOk {
-				node.Children[1].Token.Value = strings.Replace(node.Children[1].Token.Value.(string), "'", "", -1)
-			}
-			value := bson.M{"$" + node.Token.Value.(string): node.Children[1].Token.Value}
-			if _, keyOk := node.Children[0].Token.Value.(string); !keyOk {
-				return nil, ErrInvalidInput
-			}
-			filter[node.Children[0].Token.Value.(string)] = value
-
-		case "gt":
-			var keyString string
-			if keyString, ok = node.Children[0].Token.Value.(string); !ok {
-				return nil, ErrInvalidInput
-			}
-
-			var value bson.M
-			if keyString == "_id" {
-				var idString string
-				if _, ok := node.Children[1].Token.Value.(string); ok {
-					idString = strings.Replace(node.Children[1].Token.Value.(string), "'", "", -1)
-				}
-				decodedString, err := hex.DecodeString(idString)
-				if err != nil || len(decodedString) != 12 {
-					return nil, ErrInvalidInput
-				}
-				value = bson.M{"$" + node.Token.Value.(string): bson.ObjectId(decodedString)}
-			} else {
-				value = bson.M{"$" + node.Token.Value.(string): node.Children[1].Token.Value}
-			}
-			filter[keyString] = value
-
-		case "ge":
-			value := bson.M{"$gte": node.Children[1].Token.Value}
-			if _, ok := node.Children[0].Token.Value.(string); !ok {
+		case "eq", "ne", "gt", "ge", "lt", "le":
+			key, ok := node.Children[0].Token.Value.(string)
+			if !ok {
 				return nil, ErrInvalidInput
 			}
-			filter[node.Children[0].Token.Value.(string)] = value
-
-		case "lt":
-			value := bson.M{"$" + node.Token.Value.(string): node.Children[1].Token.Value}
-			if _, ok := node.Children[0].Token.Value.(string); !ok {
-				return nil, ErrInvalidInput
-			}
-			filter[node.Children[0].Token.Value.(string)] = value
-
-		case "le":
-			value := bson.M{"$lte": node.Children[1].Token.Value}
-			if _, ok := node.Children[0].Token.Value.(string); !ok {
-				return nil, ErrInvalidInput
-			}
-			filter[node.Children[0].Token.Value.(string)] = value
-
-		case "and":
-			leftFilter, err := applyFilter(node.Children[0]) // Left children
+			value, err := o.filterValue(key, node.Children[1].Token.Value)
 			if err != nil {
 				return nil, err
 			}
-			rightFilter, _ := applyFilter(node.Children[1]) // Right children
-			if err != nil {
-				return nil, err
+			filter[key] = bson.M{mongoOperators[node.Token.Value.(string)]: value}
+
+		case "and", "or":
+			// normalized trees join any number of children
+			filters := make([]bson.M, 0, len(node.Children))
+			for _, child := range node.Children {
+				childFilter, err := applyFilter(child, o)
+				if err != nil {
+					return nil, err
+				}
+				filters = append(filters, childFilter)
 			}
-			filter["$and"] = []bson.M{leftFilter, rightFilter}
+			filter["$"+node.Token.Value.(string)] = filters
 
-		case "or":
-			leftFilter, err := applyFilter(node.Children[0]) // Left children
+		case "not":
+			subFilter, err := applyFilter(node.Children[0], o)
 			if err != nil {
 				return nil, err
 			}
-			rightFilter, err := applyFilter(node.Children[1]) // Right children
-			if err != nil {
-				return nil, err
-			}
-			filter["$or"] = []bson.M{leftFilter, rightFilter}
+			filter["$nor"] = []bson.M{subFilter}
 
-		//Functions
-		case "startswith":
-			if _, ok := node.Children[1].Token.Value.(string); !ok {
+		case "in":
+			key, ok := node.Children[0].Token.Value.(string)
+			if !ok {
 				return nil, ErrInvalidInput
 			}
-			node.Children[1].Token.Value = strings.Replace(node.Children[1].Token.Value.(string), "'", "", -1)
-			//nolint: vet
-			value := bson.RegEx{"^" + node.Children[1].Token.Value.(string), "gi"}
-			filter[node.Children[0].Token.Value.(string)] = value
+			values := make([]interface{}, 0, len(node.Children)-1)
+			for _, child := range node.Children[1:] {
+				value, err := o.filterValue(key, child.Token.Value)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			filter[key] = bson.M{"$in": values}
 
-		case "endswith":
-			if _, ok := node.Children[1].Token.Value.(string); !ok {
+		//Functions
+		case "startswith", "endswith", "contains":
+			if _, ok := node.Children[0].Token.Value.(string); !ok {
 				return nil, ErrInvalidInput
 			}
-			node.Children[1].Token.Value = strings.Replace(node.Children[1].Token.Value.(string), "'", "", -1)
-			//nolint: vet
-			value := bson.RegEx{node.Children[1].Token.Value.(string) + "$", "gi"}
-			filter[node.Children[0].Token.Value.(string)] = value
-
-		case "contains":
-			if _, ok := node.Children[1].Token.Value.(string); !ok {
+			value, ok := node.Children[1].Token.Value.(string)
+			if !ok {
 				return nil, ErrInvalidInput
 			}
-			node.Children[1].Token.Value = strings.Replace(node.Children[1].Token.Value.(string), "'", "", -1)
-			//nolint: vet
-			value := bson.RegEx{node.Children[1].Token.Value.(string), "gi"}
-			filter[node.Children[0].Token.Value.(string)] = value
+			filter[node.Children[0].Token.Value.(string)] = functionRegEx(node.Token.Value.(string), unquote(value), o)
 
 		}
 	}
 	return filter, nil
 }
+
+// filterValue converts the value compared with the field: string literals are unquoted,
+// ObjectId literals and hex strings compared with ObjectId fields become bson.ObjectId,
+// other values are kept
+func (o *options) filterValue(field string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case parser.ObjectID:
+		return objectID(string(v))
+	case string:
+		// strings which are not ObjectId hex strings are kept, e.g. for collections with string ids
+		text := unquote(v)
+		if o.objectIDFields[field] && bson.IsObjectIdHex(text) {
+			return bson.ObjectIdHex(text), nil
+		}
+		return text, nil
+	}
+	return value, nil
+}
+
+func objectID(value string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(value) {
+		return "", ErrInvalidInput
+	}
+	return bson.ObjectIdHex(value), nil
+}
+
+// functionRegEx matches the value literally: regular expression metacharacters are escaped
+// and startswith is anchored at the beginning, so case sensitive prefixes can use indexes
+func functionRegEx(function string, value string, o *options) bson.RegEx {
+	pattern := regexp.QuoteMeta(value)
+	switch function {
+	case "startswith":
+		pattern = "^" + pattern
+	case "endswith":
+		pattern = pattern + "$"
+	}
+
+	regexOptions := "i"
+	if o.caseSensitive {
+		regexOptions = ""
+	}
+	return bson.RegEx{Pattern: pattern, Options: regexOptions}
+}
+
+// unquote removes the quotes of a string literal and unescapes doubled quotes
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = value[1 : len(value)-1]
+	}
+	return strings.Replace(value, "''", "'", -1)
+}
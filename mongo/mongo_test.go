@@ -9,11 +9,15 @@ package mongo
 import (
 	"fmt"
 	"net/url"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/pkg/errors"
 
 	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/intel/rsp-sw-toolkit-im-suite-go-odata/parser"
 )
 
 var dbhost = "mongodb://localhost:27017/test"
@@ -37,6 +41,42 @@ func TestODataQuery(t *testing.T) {
 		t.Errorf("Error: %s", err.Error())
 	}
 }
+
+func TestODataForEach(t *testing.T) {
+
+	mainSession, err := mgo.Dial(dbhost)
+	if err != nil {
+		t.Fatalf("Unable to connect to mongo server on %s", dbhost)
+	}
+
+	testURL, err := url.Parse("http://localhost/test?$top=10&$filter=price gt 20&$orderby=name")
+	if err != nil {
+		t.Error("failed to parse test url")
+	}
+
+	var object []interface{}
+	collection := mainSession.DB("test").C("tests")
+	if err := ODataQuery(testURL.Query(), &object, collection); err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	count := 0
+	err = ODataForEach(testURL.Query(), collection, func(raw bson.Raw) error {
+		var record bson.M
+		if err := raw.Unmarshal(&record); err != nil {
+			return err
+		}
+		count++
+		return nil
+	}, WithBatchSize(2))
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	if count != len(object) {
+		t.Errorf("Expected %d records, got %d", len(object), count)
+	}
+}
+
 func TestODataCount(t *testing.T) {
 
 	mainSession, err := mgo.Dial(dbhost)
@@ -51,6 +91,33 @@ func TestODataCount(t *testing.T) {
 	}
 }
 
+func TestODataFilteredCount(t *testing.T) {
+
+	mainSession, err := mgo.Dial(dbhost)
+	if err != nil {
+		t.Fatalf("Unable to connect to mongo server on %s", dbhost)
+	}
+
+	testURL, err := url.Parse("http://localhost/test?$filter=price gt 20&$top=1")
+	if err != nil {
+		t.Error("failed to parse test url")
+	}
+
+	collection := mainSession.DB("test").C("tests")
+
+	filtered, err := ODataFilteredCount(testURL.Query(), collection)
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	total, err := ODataCount(collection)
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	if filtered > total {
+		t.Errorf("Filtered count %d is greater than the total %d", filtered, total)
+	}
+}
+
 func TestODataFilter(t *testing.T) {
 
 	mainSession, err := mgo.Dial(dbhost)
@@ -119,7 +186,7 @@ func TestODataWithFilter(t *testing.T) {
 		Err      error
 	}{
 		{"((epc_item_type gt 0) and (event ne 'departed') and (required eq true) and (count lt 0.1) or " +
-			"(SKU eq '123') and contains(epc_time, 0) and startswith(epc_code, '456') or " +
+			"(SKU eq '123') and contains(epc_time, '0') and startswith(epc_code, '456') or " +
 			"endswith(upc_code, '789'))", true, nil}, // large valid filter case
 		{"_id gt '59a6fbaf22e60174f5107a9a' and upc_code eq 'val'", true, nil}, // paging with mongo id
 		{"gtin eq '123'", true, nil},                                       // key name with operator substring
@@ -131,6 +198,7 @@ func TestODataWithFilter(t *testing.T) {
 		{"name and epc_item_type ne 0", false, errors.New("")},             // // operators can't have a mix operators and literals
 		{"name eqs epc_item_type", false, errors.New("")},                  // typo operator
 		{"contains(and, epc_item_type)", false, errors.New("")},            // operator in function
+		{"contains(epc_time, 0)", false, errors.New("")},                   // function value is not a string
 		{"0 eq epc_item_type", false, errors.New("")},                      // integer key name
 		{"", false, errors.New("")},                                        // empty string test
 	}
@@ -159,3 +227,348 @@ func TestODataWithFilter(t *testing.T) {
 
 	}
 }
+
+func TestFilterQueryFromBuilder(t *testing.T) {
+	filter := parser.And(parser.Eq(parser.Prop("status"), parser.String("active")),
+		parser.Gt(parser.Prop("qty"), parser.Int(0)))
+
+	query, err := FilterQuery(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := bson.M{"$and": []bson.M{
+		{"status": bson.M{"$eq": "active"}},
+		{"qty": bson.M{"$gt": 0}},
+	}}
+	if !reflect.DeepEqual(query, expected) {
+		t.Errorf("Expected: %v \tGot: %v", expected, query)
+	}
+}
+
+func TestFilterQueryRejectsInvalidTree(t *testing.T) {
+	invalid := []*parser.ParseNode{
+		parser.And(),
+		parser.Or(),
+		parser.Eq(parser.Prop("a"), parser.And(parser.Eq(parser.Prop("b"), parser.Int(1)))),
+	}
+
+	for _, node := range invalid {
+		if _, err := FilterQuery(node); errors.Cause(err) != ErrInvalidInput {
+			t.Errorf("Expected ErrInvalidInput for %v, got: %v", node, err)
+		}
+	}
+}
+
+func TestFunctionsMatchLiterally(t *testing.T) {
+	var regexTests = []struct {
+		filter   string
+		opts     []Option
+		expected bson.M
+	}{
+		{"contains(name, '.*')", nil, bson.M{"name": bson.RegEx{Pattern: `\.\*`, Options: "i"}}},
+		{"startswith(name, 'a(b')", nil, bson.M{"name": bson.RegEx{Pattern: `^a\(b`, Options: "i"}}},
+		{"endswith(name, 'it''s')", nil, bson.M{"name": bson.RegEx{Pattern: `it's$`, Options: "i"}}},
+		{"startswith(name, 'Abc')", []Option{CaseSensitive()}, bson.M{"name": bson.RegEx{Pattern: `^Abc`, Options: ""}}},
+	}
+
+	for _, test := range regexTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {test.filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, err := FilterQuery(queryMap[parser.Filter].(*parser.ParseNode), test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(query, test.expected) {
+			t.Errorf("%s: Expected: %v \tGot: %v", test.filter, test.expected, query)
+		}
+	}
+
+	for _, filter := range []string{"contains(epc_time, 0)", "startswith(name, true)"} {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := FilterQuery(queryMap[parser.Filter].(*parser.ParseNode)); errors.Cause(err) != ErrInvalidInput {
+			t.Errorf("%s: expected invalid input, got %v", filter, err)
+		}
+	}
+}
+
+func TestObjectIDFields(t *testing.T) {
+	id := bson.ObjectIdHex("59a6fbaf22e60174f5107a9a")
+	var objectIDTests = []struct {
+		filter   string
+		expected bson.M
+	}{
+		{"_id eq '59a6fbaf22e60174f5107a9a'", bson.M{"_id": bson.M{"$eq": id}}},
+		{"_id le '59a6fbaf22e60174f5107a9a'", bson.M{"_id": bson.M{"$lte": id}}},
+		{"_id in ('59a6fbaf22e60174f5107a9a')", bson.M{"_id": bson.M{"$in": []interface{}{id}}}},
+		{"owner ne '59a6fbaf22e60174f5107a9a'", bson.M{"owner": bson.M{"$ne": id}}},
+		{"other in (ObjectId'59a6fbaf22e60174f5107a9a', 'x')", bson.M{"other": bson.M{"$in": []interface{}{id, "x"}}}},
+		{"other eq 'it''s'", bson.M{"other": bson.M{"$eq": "it's"}}},
+	}
+
+	for _, test := range objectIDTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {test.filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, err := FilterQuery(queryMap[parser.Filter].(*parser.ParseNode), WithObjectIDFields("owner"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(query, test.expected) {
+			t.Errorf("%s: Expected: %v \tGot: %v", test.filter, test.expected, query)
+		}
+	}
+}
+
+func TestStringIDs(t *testing.T) {
+	var stringIDTests = []struct {
+		filter   string
+		expected bson.M
+	}{
+		{"_id eq 'a1b2-c3d4'", bson.M{"_id": bson.M{"$eq": "a1b2-c3d4"}}},
+		{"_id in ('abc', 'def')", bson.M{"_id": bson.M{"$in": []interface{}{"abc", "def"}}}},
+		{"_id ge 'k'", bson.M{"_id": bson.M{"$gte": "k"}}},
+		{"_id lt 5", bson.M{"_id": bson.M{"$lt": 5}}},
+	}
+
+	for _, test := range stringIDTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {test.filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, err := FilterQuery(queryMap[parser.Filter].(*parser.ParseNode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(query, test.expected) {
+			t.Errorf("%s: Expected: %v \tGot: %v", test.filter, test.expected, query)
+		}
+	}
+}
+
+func TestScopeWrapsUserFilter(t *testing.T) {
+	scope := WithScope(parser.Eq(parser.Prop("tenantId"), parser.String("X")))
+	queryMap, err := newOptions([]Option{scope}).parse(url.Values{parser.Filter: {"a eq 1 or tenantId eq 'Y'"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := FilterQuery(queryMap[parser.Filter].(*parser.ParseNode))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := bson.M{"$and": []bson.M{
+		{"tenantId": bson.M{"$eq": "X"}},
+		{"$or": []bson.M{{"a": bson.M{"$eq": 1}}, {"tenantId": bson.M{"$eq": "Y"}}}},
+	}}
+	if !reflect.DeepEqual(query, expected) {
+		t.Errorf("Expected: %v \tGot: %v", expected, query)
+	}
+}
+
+func TestPolicyDeniesQuery(t *testing.T) {
+	policy := &parser.Policy{Unfilterable: []string{"cost"}}
+	query := url.Values{parser.Filter: {"cost gt 10"}}
+
+	var object []interface{}
+	err := ODataQuery(query, &object, nil, WithPolicy(policy, "bob"))
+	if errors.Cause(err) != parser.ErrDenied {
+		t.Errorf("Expected query to be denied, got %v", err)
+	}
+
+	_, err = ODataFilteredCount(query, nil, WithPolicy(policy, "bob"))
+	if errors.Cause(err) != parser.ErrDenied {
+		t.Errorf("Expected count to be denied, got %v", err)
+	}
+}
+
+func TestSelectFieldsExcludesHidden(t *testing.T) {
+	var selectTests = []struct {
+		input    url.Values
+		expected bson.M
+	}{
+		{url.Values{}, bson.M{"cost": 0, "supplier.notes": 0}},
+		{url.Values{parser.Select: {"*"}}, bson.M{"cost": 0, "supplier.notes": 0}},
+		{url.Values{parser.Select: {"name,qty,cost"}}, bson.M{"_id": 0, "name": 1, "qty": 1}},
+	}
+
+	access := WithFieldAccess(&parser.FieldAccess{Hidden: []string{"cost", "supplier.notes"}, Drop: true})
+	for _, test := range selectTests {
+		o := newOptions([]Option{access})
+		queryMap, err := o.parse(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := selectFields(queryMap, o.hidden()); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Expected: %v \tGot: %v", test.expected, result)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	var selectTests = []struct {
+		input    string
+		expected bson.M
+	}{
+		{"*", bson.M{}},
+		{"name", bson.M{"_id": 0, "name": 1}},
+		{"_id,name", bson.M{"_id": 1, "name": 1}},
+		{"tag.epc,qty", bson.M{"_id": 0, "tag.epc": 1, "qty": 1}},
+		{"tag.epc,tag", bson.M{"_id": 0, "tag": 1}},
+	}
+
+	for _, test := range selectTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Select: {test.input}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := selectFields(queryMap, nil); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: Expected: %v \tGot: %v", test.input, test.expected, result)
+		}
+	}
+
+	// * selects the whole document and cannot be mixed with other fields
+	if _, err := Translate(url.Values{parser.Select: {"name,*"}}); errors.Cause(err) != ErrInvalidInput {
+		t.Errorf("Expected ErrInvalidInput, got: %v", err)
+	}
+}
+
+func TestODataQueryResultInlineCount(t *testing.T) {
+
+	mainSession, err := mgo.Dial(dbhost)
+	if err != nil {
+		t.Fatalf("Unable to connect to mongo server on %s", dbhost)
+	}
+
+	testURL, err := url.Parse("http://localhost/test?$top=1&$filter=price gt 20&$inlinecount=allpages")
+	if err != nil {
+		t.Error("failed to parse test url")
+	}
+
+	var object []interface{}
+	collection := mainSession.DB("test").C("tests")
+
+	result, err := ODataQueryResult(testURL.Query(), &object, collection)
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	if !result.HasInlineCount || result.InlineCount < len(object) {
+		t.Errorf("Expected inline count of at least %d, got %d", len(object), result.InlineCount)
+	}
+	if !reflect.DeepEqual(result.Filter, bson.M{"price": bson.M{"$gt": 20}}) {
+		t.Errorf("Unexpected filter %v", result.Filter)
+	}
+}
+
+// Run with -race: every query must translate its own filter
+func TestParallelQueriesKeepTheirFilter(t *testing.T) {
+	cache := parser.NewCache(8)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tenant := fmt.Sprintf("tenant%d", i%5)
+			o := newOptions([]Option{
+				WithCache(cache),
+				WithScope(parser.Eq(parser.Prop("tenantId"), parser.String(tenant))),
+			})
+			queryMap, err := o.parse(url.Values{parser.Filter: {fmt.Sprintf("qty gt %d", i%10)}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			q, err := o.translateQuery(queryMap)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			expected := bson.M{"$and": []bson.M{
+				{"tenantId": bson.M{"$eq": tenant}},
+				{"qty": bson.M{"$gt": i % 10}},
+			}}
+			if !reflect.DeepEqual(q.Filter, expected) {
+				t.Errorf("Expected: %v \tGot: %v", expected, q.Filter)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestTranslate(t *testing.T) {
+	query := url.Values{
+		parser.Filter:  {"_id gt '59a6fbaf22e60174f5107a9a' and qty le 5"},
+		parser.Select:  {"name,qty"},
+		parser.OrderBy: {"name desc,qty"},
+		parser.Top:     {"10"},
+		parser.Skip:    {"20"},
+	}
+
+	translation, err := Translate(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Translation{
+		Filter: bson.M{"$and": []bson.M{
+			{"_id": bson.M{"$gt": bson.ObjectIdHex("59a6fbaf22e60174f5107a9a")}},
+			{"qty": bson.M{"$lte": 5}},
+		}},
+		Projection: bson.M{"_id": 0, "name": 1, "qty": 1},
+		Sort:       []string{"-name", "qty"},
+		Skip:       20,
+		Limit:      10,
+	}
+	if !reflect.DeepEqual(translation, expected) {
+		t.Errorf("Expected: %+v \tGot: %+v", expected, translation)
+	}
+
+	pipeline := translation.Pipeline()
+	expectedPipeline := []bson.M{
+		{"$match": expected.Filter},
+		{"$sort": bson.D{{Name: "name", Value: -1}, {Name: "qty", Value: 1}}},
+		{"$skip": 20},
+		{"$limit": 10},
+		{"$project": expected.Projection},
+	}
+	if !reflect.DeepEqual(pipeline, expectedPipeline) {
+		t.Errorf("Expected: %v \tGot: %v", expectedPipeline, pipeline)
+	}
+
+	data, err := translation.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedJSON := `{"filter":{"$and":[{"_id":{"$gt":{"$oid":"59a6fbaf22e60174f5107a9a"}}},{"qty":{"$lte":5}}]},` +
+		`"projection":{"_id":0,"name":1,"qty":1},"sort":["-name","qty"],"skip":20,"limit":10}`
+	if string(data) != expectedJSON {
+		t.Errorf("Expected: %s \tGot: %s", expectedJSON, data)
+	}
+}
+
+func TestTranslateQueryAppliesScope(t *testing.T) {
+	queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {"qty gt 1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	translation, err := TranslateQuery(queryMap, WithScope(parser.Eq(parser.Prop("tenantId"), parser.String("X"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bson.M{"$and": []bson.M{
+		{"tenantId": bson.M{"$eq": "X"}},
+		{"qty": bson.M{"$gt": 1}},
+	}}
+	if !reflect.DeepEqual(translation.Filter, expected) {
+		t.Errorf("Expected: %v \tGot: %v", expected, translation.Filter)
+	}
+}
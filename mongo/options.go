@@ -0,0 +1,148 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package mongo
+
+import (
+	"net/url"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-go-odata/parser"
+	"github.com/pkg/errors"
+)
+
+// Option configures how odata queries are parsed and translated
+type Option func(*options)
+
+type options struct {
+	cache     *parser.Cache
+	scope     *parser.ParseNode
+	policy    *parser.Policy
+	principal interface{}
+	fields    *parser.FieldAccess
+
+	caseSensitive  bool
+	objectIDFields map[string]bool
+	batchSize      int
+}
+
+// WithCache parses the odata queries through the given cache
+func WithCache(cache *parser.Cache) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
+// WithScope constrains every query with a server enforced filter, e.g. tenantId eq 'X'.
+// The client $filter is joined with an and below the scope so it cannot widen it.
+func WithScope(scope *parser.ParseNode) Option {
+	return func(o *options) {
+		o.scope = scope
+	}
+}
+
+// WithPolicy applies the row-level security policy of the entity set for the principal
+// making the request. Queries denied by the policy fail with its error.
+func WithPolicy(policy *parser.Policy, principal interface{}) Option {
+	return func(o *options) {
+		o.policy = policy
+		o.principal = principal
+	}
+}
+
+// WithFieldAccess hides fields from the principal making the request. Hidden fields are
+// rejected or dropped from $select, excluded from the default projection and cannot be
+// used in $filter or $orderby.
+func WithFieldAccess(access *parser.FieldAccess) Option {
+	return func(o *options) {
+		o.fields = access
+	}
+}
+
+// CaseSensitive makes contains, startswith and endswith match the case of the value.
+// By default they ignore case, but only case sensitive startswith can use an index.
+func CaseSensitive() Option {
+	return func(o *options) {
+		o.caseSensitive = true
+	}
+}
+
+// WithObjectIDFields compares the given fields, in addition to _id, as ObjectIds:
+// ObjectId hex strings compared with them are converted into bson.ObjectId, other values are kept.
+// ObjectId'<hex>' literals are converted whatever the field.
+func WithObjectIDFields(fields ...string) Option {
+	return func(o *options) {
+		for _, field := range fields {
+			o.objectIDFields[field] = true
+		}
+	}
+}
+
+// WithBatchSize sets the number of documents fetched from the server per batch
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{objectIDFields: map[string]bool{"_id": true}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// parse parses the url values, using the cache when one is configured,
+// and applies the field access, the policy and the server enforced scope.
+// Syntax errors are wrapped in ErrInvalidInput.
+func (o *options) parse(query url.Values) (map[string]interface{}, error) {
+	var queryMap map[string]interface{}
+	var err error
+	if o.cache != nil {
+		queryMap, err = o.cache.ParseURLValues(query)
+	} else {
+		queryMap, err = parser.ParseURLValues(query)
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidInput, err.Error())
+	}
+
+	if err := o.constrain(queryMap); err != nil {
+		return nil, err
+	}
+	return queryMap, nil
+}
+
+// constrain applies the field access, the policy and the server enforced scope to the parsed query
+func (o *options) constrain(queryMap map[string]interface{}) error {
+	if o.fields != nil {
+		if err := o.fields.Apply(queryMap); err != nil {
+			return err
+		}
+	}
+	if o.policy != nil {
+		if err := o.policy.Apply(o.principal, queryMap); err != nil {
+			return err
+		}
+	}
+	return parser.ApplyScope(queryMap, o.scope)
+}
+
+// hidden returns the fields hidden from the principal
+func (o *options) hidden() []string {
+	if o.fields == nil {
+		return nil
+	}
+	return o.fields.Hidden
+}
+
+// filterOptions returns the options used to translate filters
+func (o *options) filterOptions() []Option {
+	return []Option{func(filterOptions *options) {
+		filterOptions.caseSensitive = o.caseSensitive
+		filterOptions.objectIDFields = o.objectIDFields
+	}}
+}
@@ -0,0 +1,187 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package mongo
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/intel/rsp-sw-toolkit-im-suite-go-odata/parser"
+)
+
+// Translation is the mgo query translated from odata parameters. It can be inspected
+// or logged without a database and runs the same query as ODataQuery.
+type Translation struct {
+	// Filter is the query document
+	Filter bson.M `json:"filter"`
+	// Projection selects the fields of the documents
+	Projection bson.M `json:"projection,omitempty"`
+	// Sort lists the sort fields, descending fields are prefixed with -
+	Sort []string `json:"sort,omitempty"`
+	// Skip is the number of documents to skip
+	Skip int `json:"skip,omitempty"`
+	// Limit is the maximum number of documents, 0 means no limit
+	Limit int `json:"limit,omitempty"`
+	// InlineCount reports whether $inlinecount=allpages was requested
+	InlineCount bool `json:"inlinecount,omitempty"`
+}
+
+// Translate parses the odata parameters and translates them into a mgo query,
+// applying the same options as ODataQuery
+func Translate(query url.Values, opts ...Option) (*Translation, error) {
+	return newOptions(opts).translate(query)
+}
+
+// TranslateQuery translates a query parsed by the parser package, e.g. decoded with
+// parser.UnmarshalQuery. The field access, policy and scope options modify queryMap.
+func TranslateQuery(queryMap map[string]interface{}, opts ...Option) (*Translation, error) {
+	o := newOptions(opts)
+	if err := o.constrain(queryMap); err != nil {
+		return nil, err
+	}
+	return o.translateQuery(queryMap)
+}
+
+// Find creates the mgo query of the translation on the collection
+func (t *Translation) Find(collection *mgo.Collection) *mgo.Query {
+	return collection.Find(t.Filter).Select(t.Projection).Limit(t.Limit).Skip(t.Skip).Sort(t.Sort...)
+}
+
+// Pipeline returns the equivalent aggregation pipeline, e.g. to add stages after the query
+func (t *Translation) Pipeline() []bson.M {
+	pipeline := []bson.M{{"$match": t.Filter}}
+	if len(t.Sort) > 0 {
+		sort := make(bson.D, 0, len(t.Sort))
+		for _, field := range t.Sort {
+			if strings.HasPrefix(field, "-") {
+				sort = append(sort, bson.DocElem{Name: field[1:], Value: -1})
+			} else {
+				sort = append(sort, bson.DocElem{Name: field, Value: 1})
+			}
+		}
+		pipeline = append(pipeline, bson.M{"$sort": sort})
+	}
+	if t.Skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": t.Skip})
+	}
+	if t.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": t.Limit})
+	}
+	if len(t.Projection) > 0 {
+		pipeline = append(pipeline, bson.M{"$project": t.Projection})
+	}
+	return pipeline
+}
+
+// JSON dumps the translation as MongoDB extended JSON, e.g. {"$oid":"..."} for ObjectIds
+func (t *Translation) JSON() ([]byte, error) {
+	data, err := bson.MarshalJSON(t)
+	if err != nil {
+		return nil, err
+	}
+	// the encoder ends the value with a newline
+	return bytes.TrimSuffix(data, []byte("\n")), nil
+}
+
+// translate parses the url values and translates them
+func (o *options) translate(query url.Values) (*Translation, error) {
+	queryMap, err := o.parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return o.translateQuery(queryMap)
+}
+
+// find creates the mgo query of the translation with the batch size of the options
+func (o *options) find(t *Translation, collection *mgo.Collection) *mgo.Query {
+	query := t.Find(collection)
+	if o.batchSize > 0 {
+		query = query.Batch(o.batchSize)
+	}
+	return query
+}
+
+// translateQuery translates the parsed odata query
+//nolint :gocyclo
+func (o *options) translateQuery(queryMap map[string]interface{}) (*Translation, error) {
+	t := &Translation{Filter: make(bson.M)}
+
+	t.Limit, _ = queryMap[parser.Top].(int)
+	t.Skip, _ = queryMap[parser.Skip].(int)
+	t.InlineCount = queryMap[parser.InlineCount] == "allpages"
+
+	if queryMap[parser.Filter] != nil {
+		filterQuery, _ := queryMap[parser.Filter].(*parser.ParseNode)
+		var err error
+		t.Filter, err = FilterQuery(filterQuery, o.filterOptions()...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Prepare Select
+	t.Projection = selectFields(queryMap, o.hidden())
+
+	// Sort
+	if queryMap[parser.OrderBy] != nil {
+		orderBySlice := queryMap[parser.OrderBy].([]parser.OrderItem)
+		for _, item := range orderBySlice {
+			if item.Order == "desc" {
+				item.Field = "-" + item.Field
+			}
+			t.Sort = append(t.Sort, item.Field)
+		}
+	}
+	return t, nil
+}
+
+// selectFields builds the projection of $select. _id is only returned when it is selected
+// and * selects the whole documents. Hidden fields are excluded from the whole documents.
+func selectFields(queryMap map[string]interface{}, hidden []string) bson.M {
+	selectMap := make(bson.M)
+
+	selectSlice, _ := queryMap[parser.Select].([]string)
+	if len(selectSlice) == 0 || containsField(selectSlice, "*") {
+		for _, field := range hidden {
+			selectMap[field] = 0
+		}
+		return selectMap
+	}
+
+	for _, fieldName := range selectSlice {
+		// mongo rejects a nested path along with its parent
+		if !selectsParent(fieldName, selectSlice) {
+			selectMap[fieldName] = 1
+		}
+	}
+	if _, ok := selectMap["_id"]; !ok {
+		selectMap["_id"] = 0
+	}
+	return selectMap
+}
+
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsParent reports whether a parent of the nested field is selected as well
+func selectsParent(field string, fields []string) bool {
+	for _, f := range fields {
+		if strings.HasPrefix(field, f+".") {
+			return true
+		}
+	}
+	return false
+}
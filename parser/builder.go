@@ -0,0 +1,161 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectID is the value of an ObjectId'<hex>' literal: the 24 lowercase hex digits of a
+// mongo ObjectId. Adapters without ObjectIds compare it as a string.
+type ObjectID string
+
+// objectIDLength is the number of hex digits of an ObjectId
+const objectIDLength = 24
+
+// Functions to build filter trees in Go instead of concatenating odata strings, e.g.
+//   And(Eq(Prop("status"), String("active")), Gt(Prop("qty"), Int(0)))
+// The trees are identical to the ones produced by parsing the equivalent $filter.
+
+// Prop references a property (field) of the records
+func Prop(name string) *ParseNode {
+	return leafNode(filterTokenLiteral, name, name)
+}
+
+// String creates a string literal
+func String(value string) *ParseNode {
+	quoted := "'" + strings.Replace(value, "'", "''", -1) + "'"
+	return leafNode(filterTokenString, quoted, quoted)
+}
+
+// Int creates an integer literal
+func Int(value int) *ParseNode {
+	return leafNode(filterTokenInteger, strconv.Itoa(value), value)
+}
+
+// Float creates a floating point literal
+func Float(value float64) *ParseNode {
+	text := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(text, ".eE") {
+		text += ".0"
+	}
+	return leafNode(filterTokenFloat, text, value)
+}
+
+// Bool creates a boolean literal
+func Bool(value bool) *ParseNode {
+	return leafNode(filterTokenBoolean, strconv.FormatBool(value), value)
+}
+
+// Date creates a date literal, the time of day is ignored
+func Date(value time.Time) *ParseNode {
+	text := value.Format(dateLayout)
+	date, _ := time.Parse(dateLayout, text)
+	return leafNode(filterTokenDate, text, date)
+}
+
+// DateTime creates a date and time literal
+func DateTime(value time.Time) *ParseNode {
+	return leafNode(filterTokenDateTime, value.Format(dateTimeLayout), value)
+}
+
+// ObjectIDHex creates an ObjectId literal from its hex representation.
+// Use Validate to check trees built from untrusted values.
+func ObjectIDHex(value string) *ParseNode {
+	value = strings.ToLower(value)
+	return leafNode(filterTokenObjectID, "ObjectId'"+value+"'", ObjectID(value))
+}
+
+// Eq creates an equal comparison
+func Eq(left, right *ParseNode) *ParseNode {
+	return operatorNode("eq", left, right)
+}
+
+// Ne creates a not equal comparison
+func Ne(left, right *ParseNode) *ParseNode {
+	return operatorNode("ne", left, right)
+}
+
+// Gt creates a greater than comparison
+func Gt(left, right *ParseNode) *ParseNode {
+	return operatorNode("gt", left, right)
+}
+
+// Ge creates a greater than or equal comparison
+func Ge(left, right *ParseNode) *ParseNode {
+	return operatorNode("ge", left, right)
+}
+
+// Lt creates a less than comparison
+func Lt(left, right *ParseNode) *ParseNode {
+	return operatorNode("lt", left, right)
+}
+
+// Le creates a less than or equal comparison
+func Le(left, right *ParseNode) *ParseNode {
+	return operatorNode("le", left, right)
+}
+
+// In checks the property against a list of values
+func In(property *ParseNode, values ...*ParseNode) *ParseNode {
+	return operatorNode("in", append([]*ParseNode{property}, values...)...)
+}
+
+// And joins the predicates like a chain of and operators, And(a, b, c) is ((a and b) and c)
+func And(predicates ...*ParseNode) *ParseNode {
+	return chain("and", predicates)
+}
+
+// Or joins the predicates like a chain of or operators, Or(a, b, c) is ((a or b) or c)
+func Or(predicates ...*ParseNode) *ParseNode {
+	return chain("or", predicates)
+}
+
+// Not negates the predicate
+func Not(predicate *ParseNode) *ParseNode {
+	return operatorNode("not", predicate)
+}
+
+// Contains checks if the property contains the value
+func Contains(property, value *ParseNode) *ParseNode {
+	return functionNode("contains", property, value)
+}
+
+// StartsWith checks if the property starts with the value
+func StartsWith(property, value *ParseNode) *ParseNode {
+	return functionNode("startswith", property, value)
+}
+
+// EndsWith checks if the property ends with the value
+func EndsWith(property, value *ParseNode) *ParseNode {
+	return functionNode("endswith", property, value)
+}
+
+func leafNode(tokenType int, text string, value interface{}) *ParseNode {
+	return &ParseNode{&Token{stringValue: text, Value: value, Type: tokenType}, nil, make([]*ParseNode, 0)}
+}
+
+func operatorNode(operator string, children ...*ParseNode) *ParseNode {
+	return &ParseNode{operatorToken(operator), nil, children}
+}
+
+func functionNode(function string, children ...*ParseNode) *ParseNode {
+	return &ParseNode{&Token{stringValue: function, Value: function, Type: filterTokenFunc}, nil, children}
+}
+
+func chain(operator string, predicates []*ParseNode) *ParseNode {
+	if len(predicates) == 0 {
+		return nil
+	}
+	node := predicates[0]
+	for _, predicate := range predicates[1:] {
+		node = operatorNode(operator, node, predicate)
+	}
+	return node
+}
@@ -0,0 +1,45 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuilderMatchesParser(t *testing.T) {
+	var builderTests = []struct {
+		filter string
+		built  *ParseNode
+	}{
+		{"status eq 'active' and qty gt 0",
+			And(Eq(Prop("status"), String("active")), Gt(Prop("qty"), Int(0)))},
+		{"a eq 1 or b ne -2.5 or c le true",
+			Or(Eq(Prop("a"), Int(1)), Ne(Prop("b"), Float(-2.5)), Le(Prop("c"), Bool(true)))},
+		{"not (contains(name, 'it''s') or startswith(name, 'a')) and endswith(name, 'z')",
+			And(Not(Or(Contains(Prop("name"), String("it's")), StartsWith(Prop("name"), String("a")))),
+				EndsWith(Prop("name"), String("z")))},
+		{"sku in ('1', '2') and price ge 2.0 and price lt 10",
+			And(In(Prop("sku"), String("1"), String("2")), Ge(Prop("price"), Float(2)), Lt(Prop("price"), Int(10)))},
+		{"created ge 2019-08-01 and updated lt 2019-08-01T10:30:00Z",
+			And(Ge(Prop("created"), Date(time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC))),
+				Lt(Prop("updated"), DateTime(time.Date(2019, 8, 1, 10, 30, 0, 0, time.UTC))))},
+		{"owner in (ObjectId'59a6fbaf22e60174f5107a9a', ObjectId'59a6fbaf22e60174f5107a9b')",
+			In(Prop("owner"), ObjectIDHex("59a6fbaf22e60174f5107a9a"), ObjectIDHex("59A6FBAF22E60174F5107A9B"))},
+	}
+
+	for _, test := range builderTests {
+		parsed, err := parseFilterString(test.filter)
+		if err != nil {
+			t.Errorf("%s: %s", test.filter, err)
+			continue
+		}
+		if treeString(parsed) != treeString(test.built) || nodeKey(parsed) != nodeKey(test.built) {
+			t.Errorf("%s: expected %s, built %s", test.filter, treeString(parsed), treeString(test.built))
+		}
+	}
+}
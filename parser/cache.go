@@ -0,0 +1,92 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"container/list"
+	"net/url"
+	"sync"
+)
+
+// Cache is a bounded LRU cache of parsed odata queries keyed on the normalized query string.
+// Every lookup returns a deep copy of the cached result, so callers (and the DB adapters)
+// can modify the returned values without corrupting the cached entry.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cacheEntry struct {
+	key    string
+	result map[string]interface{}
+	err    error
+}
+
+// NewCache creates a cache holding at most size parsed queries
+func NewCache(size int) *Cache {
+	if size < 1 {
+		size = 1
+	}
+	return &Cache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// ParseURLValues parses url values like the package level ParseURLValues,
+// reusing the result of a previous parse of the same query
+func (c *Cache) ParseURLValues(query url.Values) (map[string]interface{}, error) {
+	// Encode sorts by key, so the order of the options does not matter
+	key := query.Encode()
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		entry := element.Value.(*cacheEntry)
+		c.mu.Unlock()
+		return copyQuery(entry.result), entry.err
+	}
+	c.mu.Unlock()
+
+	result, err := ParseURLValues(query)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key, result, err})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+	return copyQuery(result), err
+}
+
+// copyQuery deep copies a parsed query
+func copyQuery(query map[string]interface{}) map[string]interface{} {
+	if query == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(query))
+	for key, value := range query {
+		switch v := value.(type) {
+		case *ParseNode:
+			result[key] = v.Clone()
+		case []string:
+			result[key] = append([]string(nil), v...)
+		case []OrderItem:
+			result[key] = append([]OrderItem(nil), v...)
+		default:
+			result[key] = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCacheReturnsCopies(t *testing.T) {
+	cache := NewCache(10)
+	query := url.Values{Filter: {"name eq 'val'"}, Select: {"name,age"}}
+
+	first, err := cache.ParseURLValues(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// simulate the adapters stripping quotes in place
+	first[Filter].(*ParseNode).Children[1].Token.Value = "val"
+	first[Select].([]string)[0] = "changed"
+
+	second, err := cache.ParseURLValues(url.Values{Select: {"name,age"}, Filter: {"name eq 'val'"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := second[Filter].(*ParseNode).Children[1].Token.Value; value != "'val'" {
+		t.Errorf("cached filter was modified, got %v", value)
+	}
+	if !reflect.DeepEqual(second[Select], []string{"name", "age"}) {
+		t.Errorf("cached select was modified, got %v", second[Select])
+	}
+	if cache.order.Len() != 1 {
+		t.Errorf("expected a single cache entry, got %d", cache.order.Len())
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache := NewCache(2)
+	queries := []url.Values{
+		{Top: {"1"}},
+		{Top: {"2"}},
+		{Top: {"1"}},
+		{Top: {"3"}},
+	}
+	for _, query := range queries {
+		if _, err := cache.ParseURLValues(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := cache.entries[url.Values{Top: {"2"}}.Encode()]; ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, ok := cache.entries[url.Values{Top: {"1"}}.Encode()]; !ok {
+		t.Error("recently used entry was evicted")
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	cache := NewCache(2)
+	for i := 0; i < 2; i++ {
+		result, err := cache.ParseURLValues(url.Values{Top: {"top"}})
+		if err == nil || result != nil {
+			t.Error("Failed to catch error")
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"github.com/pkg/errors"
+)
+
+// FieldAccess hides fields from a principal, e.g. cost price or supplier notes for some roles
+type FieldAccess struct {
+	// Fields the principal is not allowed to see
+	Hidden []string
+	// Drop silently removes hidden fields from $select instead of rejecting the query
+	Drop bool
+}
+
+// Apply removes or rejects hidden fields in $select and rejects them in $filter and $orderby.
+// Errors have ErrDenied as cause. The query is modified in place.
+// The adapters also exclude the hidden fields from the default projection.
+func (f *FieldAccess) Apply(query map[string]interface{}) error {
+	if selectSlice, ok := query[Select].([]string); ok {
+		visible := make([]string, 0, len(selectSlice))
+		for _, field := range selectSlice {
+			if !f.IsHidden(field) {
+				visible = append(visible, field)
+				continue
+			}
+			if !f.Drop {
+				return errors.Wrapf(ErrDenied, "selecting field '%s' is not allowed", field)
+			}
+		}
+		if len(visible) == 0 {
+			return errors.Wrap(ErrDenied, "none of the selected fields are visible")
+		}
+		query[Select] = visible
+	}
+
+	if filter, ok := query[Filter].(*ParseNode); ok && filter != nil {
+		if field, found := findProperty(filter, f.Hidden); found {
+			return errors.Wrapf(ErrDenied, "filtering on field '%s' is not allowed", field)
+		}
+	}
+	if orderBy, ok := query[OrderBy].([]OrderItem); ok {
+		for _, item := range orderBy {
+			if f.IsHidden(item.Field) {
+				return errors.Wrapf(ErrDenied, "ordering on field '%s' is not allowed", item.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// IsHidden reports whether the field, one of its parents or one of its nested fields is hidden
+func (f *FieldAccess) IsHidden(field string) bool {
+	return matchesField(field, f.Hidden)
+}
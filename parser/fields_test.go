@@ -0,0 +1,79 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestFieldAccessSelect(t *testing.T) {
+	var selectTests = []struct {
+		access   FieldAccess
+		input    string
+		expected []string
+		denied   bool
+	}{
+		{FieldAccess{Hidden: []string{"cost"}}, "name,qty", []string{"name", "qty"}, false},
+		{FieldAccess{Hidden: []string{"cost"}}, "name,cost", nil, true},
+		{FieldAccess{Hidden: []string{"cost"}, Drop: true}, "name,cost.amount", []string{"name"}, false},
+		{FieldAccess{Hidden: []string{"supplier.notes"}, Drop: true}, "supplier,name", []string{"name"}, false},
+		{FieldAccess{Hidden: []string{"cost"}, Drop: true}, "cost", nil, true},
+	}
+
+	for _, test := range selectTests {
+		query, err := ParseURLValues(url.Values{Select: {test.input}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = test.access.Apply(query)
+		if test.denied {
+			if errors.Cause(err) != ErrDenied {
+				t.Errorf("%s: expected query to be denied, got %v", test.input, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(query[Select], test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.input, test.expected, query[Select])
+		}
+	}
+}
+
+func TestFieldAccessFilterAndOrderBy(t *testing.T) {
+	access := FieldAccess{Hidden: []string{"cost", "supplier.notes"}, Drop: true}
+	queries := []url.Values{
+		{Filter: {"name eq 'a' or cost gt 10"}},
+		{Filter: {"contains(supplier,'secret')"}},
+		{Filter: {"supplier.notes eq 'x'"}},
+		{OrderBy: {"name,cost desc"}},
+		{OrderBy: {"supplier"}},
+	}
+	for _, values := range queries {
+		query, err := ParseURLValues(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := access.Apply(query); errors.Cause(err) != ErrDenied {
+			t.Errorf("%v: expected query to be denied, got %v", values, err)
+		}
+	}
+
+	query, err := ParseURLValues(url.Values{Filter: {"supplier.name eq 'x'"}, OrderBy: {"supplier.name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := access.Apply(query); err != nil {
+		t.Errorf("expected a visible nested field to be allowed, got %v", err)
+	}
+}
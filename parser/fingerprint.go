@@ -0,0 +1,83 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fingerprint returns a stable hash of a parsed query, to group queries by shape in metrics,
+// key result caches or reuse prepared statements. The filter is normalized and the operands
+// of and/or are sorted, so semantically identical urls (whitespace, option order, operand
+// order) share a fingerprint. With maskLiterals, literal values, $top and $skip are replaced
+// by their type so queries only differing in values also share it.
+func Fingerprint(query map[string]interface{}, maskLiterals bool) string {
+	var canonical strings.Builder
+
+	if filter, ok := query[Filter].(*ParseNode); ok && filter != nil {
+		canonical.WriteString("filter=")
+		canonical.WriteString(canonicalNode(Normalize(filter), maskLiterals))
+	}
+	if selectSlice, ok := query[Select].([]string); ok {
+		fields := append([]string(nil), selectSlice...)
+		sort.Strings(fields)
+		fmt.Fprintf(&canonical, ";select=%s", strings.Join(fields, ","))
+	}
+	if orderBy, ok := query[OrderBy].([]OrderItem); ok {
+		canonical.WriteString(";orderby=")
+		for i, item := range orderBy {
+			if i > 0 {
+				canonical.WriteByte(',')
+			}
+			fmt.Fprintf(&canonical, "%s %s", item.Field, item.Order)
+		}
+	}
+	for _, key := range []string{Top, Skip} {
+		if value, ok := query[key].(int); ok {
+			if maskLiterals {
+				fmt.Fprintf(&canonical, ";%s=?", key)
+			} else {
+				fmt.Fprintf(&canonical, ";%s=%d", key, value)
+			}
+		}
+	}
+	fmt.Fprintf(&canonical, ";count=%v;inlinecount=%v", query[Count] == true, query[InlineCount])
+
+	hash := sha256.Sum256([]byte(canonical.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// canonicalNode prints the tree with the operands of and/or and the values of in sorted
+func canonicalNode(node *ParseNode, maskLiterals bool) string {
+	switch node.Token.Type {
+	case filterTokenLogical, filterTokenFunc:
+		children := make([]string, len(node.Children))
+		for i, child := range node.Children {
+			children[i] = canonicalNode(child, maskLiterals)
+		}
+		switch node.Token.stringValue {
+		case "and", "or":
+			sort.Strings(children)
+		case "in":
+			sort.Strings(children[1:])
+		}
+		return "(" + node.Token.stringValue + " " + strings.Join(children, " ") + ")"
+
+	case filterTokenLiteral:
+		return node.Token.stringValue
+	}
+
+	typeName := literalTypeNames[node.Token.Type]
+	if maskLiterals {
+		return "?" + typeName
+	}
+	return fmt.Sprintf("%s:%q", typeName, fmt.Sprint(constantValue(node)))
+}
@@ -0,0 +1,53 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	var fingerprintTests = []struct {
+		first        string
+		second       string
+		maskLiterals bool
+		same         bool
+	}{
+		{"$filter=a eq 1 and b eq 'x'&$top=10", "$top=10&$filter=(b  eq 'x')\tand a eq 1", false, true},
+		{"$filter=a eq 1 or (b eq 2 or c eq 3)", "$filter=c eq 3 or b eq 2 or a eq 1", false, true},
+		{"$filter=a eq 1 or a eq 2", "$filter=a in (2, 1)", false, true},
+		{"$filter=not (a eq 1 and b eq 2)", "$filter=b ne 2 or a ne 1", false, true},
+		{"$select=a,b&$orderby=a desc", "$orderby=a desc&$select=b,a", false, true},
+		{"$filter=a eq 1", "$filter=a eq 2", false, false},
+		{"$filter=a eq 1&$top=10", "$filter=a eq 2&$top=20", true, true},
+		{"$filter=a eq 1", "$filter=a eq '1'", true, false},
+		{"$filter=a eq 1", "$filter=a gt 1", true, false},
+		{"$orderby=a,b", "$orderby=b,a", true, false},
+		{"$top=10", "$top=10&$inlinecount=allpages", true, false},
+	}
+
+	for _, test := range fingerprintTests {
+		first := fingerprintOf(t, test.first, test.maskLiterals)
+		second := fingerprintOf(t, test.second, test.maskLiterals)
+		if (first == second) != test.same {
+			t.Errorf("%s and %s: expected same fingerprint to be %t", test.first, test.second, test.same)
+		}
+	}
+}
+
+func fingerprintOf(t *testing.T, rawQuery string, maskLiterals bool) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := ParseURLValues(values)
+	if err != nil {
+		t.Fatalf("%s: %s", rawQuery, err)
+	}
+	return Fingerprint(query, maskLiterals)
+}
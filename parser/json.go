@@ -0,0 +1,356 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// QueryVersion is the version of the JSON encoding of parsed queries
+const QueryVersion = 1
+
+// Names of the literal types in the JSON encoding
+var literalTypeNames = map[int]string{
+	filterTokenString:   "string",
+	filterTokenInteger:  "int",
+	filterTokenFloat:    "float",
+	filterTokenBoolean:  "bool",
+	filterTokenDate:     "date",
+	filterTokenDateTime: "datetime",
+	filterTokenTime:     "time",
+	filterTokenObjectID: "objectid",
+}
+
+// LiteralType returns the type of a literal as named in the JSON encoding, e.g. "int" or
+// "datetime", or an empty string for properties, operators and functions
+func (n *ParseNode) LiteralType() string {
+	if n.Token == nil {
+		return ""
+	}
+	return literalTypeNames[n.Token.Type]
+}
+
+// nodeJSON is the JSON encoding of a node, exactly one of Op, Func, Prop and Type is set:
+//   {"op":"eq","args":[{"prop":"name"},{"type":"string","value":"abc"}]}
+//   {"func":"contains","args":[...]}
+type nodeJSON struct {
+	Op    string          `json:"op,omitempty"`
+	Func  string          `json:"func,omitempty"`
+	Prop  string          `json:"prop,omitempty"`
+	Type  string          `json:"type,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Args  []*ParseNode    `json:"args,omitempty"`
+}
+
+// queryJSON is the JSON encoding of a parsed query
+type queryJSON struct {
+	Version     int         `json:"version"`
+	Filter      *ParseNode  `json:"filter,omitempty"`
+	Select      []string    `json:"select,omitempty"`
+	OrderBy     []OrderItem `json:"orderby,omitempty"`
+	Top         *int        `json:"top,omitempty"`
+	Skip        *int        `json:"skip,omitempty"`
+	Count       bool        `json:"count,omitempty"`
+	InlineCount string      `json:"inlinecount,omitempty"`
+}
+
+// MarshalQuery encodes a parsed query into versioned JSON, so it can be forwarded to
+// other services without re-parsing the url
+func MarshalQuery(query map[string]interface{}) ([]byte, error) {
+	result := queryJSON{Version: QueryVersion}
+	result.Filter, _ = query[Filter].(*ParseNode)
+	result.Select, _ = query[Select].([]string)
+	result.OrderBy, _ = query[OrderBy].([]OrderItem)
+	if top, ok := query[Top].(int); ok {
+		result.Top = &top
+	}
+	if skip, ok := query[Skip].(int); ok {
+		result.Skip = &skip
+	}
+	result.Count, _ = query[Count].(bool)
+	if inlineCount, _ := query[InlineCount].(string); inlineCount != "none" {
+		result.InlineCount = inlineCount
+	}
+	return json.Marshal(&result)
+}
+
+// UnmarshalQuery decodes and validates a query encoded by MarshalQuery into the same
+// map ParseURLValues returns
+func UnmarshalQuery(data []byte) (map[string]interface{}, error) {
+	var decoded queryJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	if decoded.Version != QueryVersion {
+		return nil, fmt.Errorf("unsupported query version %d", decoded.Version)
+	}
+
+	result := make(map[string]interface{})
+	result[Count] = decoded.Count
+	result[InlineCount] = "none"
+
+	if decoded.InlineCount != "" {
+		if !isValidInlineCountValue(decoded.InlineCount) {
+			return nil, errors.New("Inline count value needs to be allpages or none")
+		}
+		if decoded.Count {
+			return nil, errors.New("$count and $inlinecount cannot be set in the same odata query")
+		}
+		result[InlineCount] = decoded.InlineCount
+	}
+	if decoded.Filter != nil {
+		if err := Validate(decoded.Filter); err != nil {
+			return nil, err
+		}
+		result[Filter] = decoded.Filter
+	}
+	if decoded.Select != nil {
+		if err := validateSelect(decoded.Select); err != nil {
+			return nil, err
+		}
+		for _, field := range decoded.Select {
+			if field != "*" && !isIdentifier(field) {
+				return nil, fmt.Errorf("invalid select field '%s'", field)
+			}
+		}
+		result[Select] = decoded.Select
+	}
+	if decoded.OrderBy != nil {
+		for _, item := range decoded.OrderBy {
+			if !isIdentifier(item.Field) || (item.Order != "asc" && item.Order != "desc") {
+				return nil, fmt.Errorf("invalid orderby item %+v", item)
+			}
+		}
+		result[OrderBy] = decoded.OrderBy
+	}
+	if decoded.Top != nil {
+		result[Top] = *decoded.Top
+	}
+	if decoded.Skip != nil {
+		result[Skip] = *decoded.Skip
+	}
+	return result, nil
+}
+
+// MarshalJSON encodes the tree with typed literals
+func (n *ParseNode) MarshalJSON() ([]byte, error) {
+	if n.Token == nil {
+		return nil, errors.New("cannot encode node without token")
+	}
+
+	var encoded nodeJSON
+	switch n.Token.Type {
+	case filterTokenLogical:
+		encoded.Op = n.Token.stringValue
+		encoded.Args = n.Children
+	case filterTokenFunc:
+		encoded.Func = n.Token.stringValue
+		encoded.Args = n.Children
+	case filterTokenLiteral:
+		encoded.Prop = n.Token.stringValue
+	default:
+		typeName, ok := literalTypeNames[n.Token.Type]
+		if !ok {
+			return nil, fmt.Errorf("cannot encode token '%s'", n.Token.stringValue)
+		}
+		encoded.Type = typeName
+
+		value := n.Token.Value
+		switch n.Token.Type {
+		case filterTokenString:
+			value = unquote(n.Token.stringValue)
+		case filterTokenDate, filterTokenDateTime, filterTokenTime:
+			// keep the text of the literal, e.g. the zone offset
+			value = n.Token.stringValue
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		encoded.Value = raw
+	}
+	return json.Marshal(&encoded)
+}
+
+// UnmarshalJSON decodes a tree encoded by MarshalJSON and checks its structure
+func (n *ParseNode) UnmarshalJSON(data []byte) error {
+	var decoded nodeJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	var node *ParseNode
+	switch {
+	case decoded.Op != "" && decoded.Func == "" && decoded.Prop == "" && decoded.Type == "":
+		if _, ok := globalFilterParser.Operators[decoded.Op]; !ok {
+			return fmt.Errorf("unknown operator '%s'", decoded.Op)
+		}
+		node = operatorNode(decoded.Op, decoded.Args...)
+	case decoded.Func != "" && decoded.Prop == "" && decoded.Type == "":
+		if _, ok := globalFilterParser.Functions[decoded.Func]; !ok {
+			return fmt.Errorf("unknown function '%s'", decoded.Func)
+		}
+		node = functionNode(decoded.Func, decoded.Args...)
+	case decoded.Prop != "" && decoded.Type == "" && len(decoded.Args) == 0:
+		node = Prop(decoded.Prop)
+	case decoded.Type != "" && len(decoded.Args) == 0:
+		var err error
+		if node, err = decodeLiteral(decoded.Type, decoded.Value); err != nil {
+			return err
+		}
+	default:
+		return errors.New("node needs exactly one of op, func, prop or type")
+	}
+
+	if err := validateNode(node); err != nil {
+		return err
+	}
+	*n = *node
+	return nil
+}
+
+func decodeLiteral(typeName string, raw json.RawMessage) (*ParseNode, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%s literal without value", typeName)
+	}
+
+	var err error
+	switch typeName {
+	case "string":
+		var value string
+		if err = json.Unmarshal(raw, &value); err == nil {
+			return String(value), nil
+		}
+	case "int":
+		var value int
+		if err = json.Unmarshal(raw, &value); err == nil {
+			return Int(value), nil
+		}
+	case "float":
+		var value float64
+		if err = json.Unmarshal(raw, &value); err == nil {
+			return Float(value), nil
+		}
+	case "bool":
+		var value bool
+		if err = json.Unmarshal(raw, &value); err == nil {
+			return Bool(value), nil
+		}
+	case "date", "datetime", "time":
+		var text string
+		if err = json.Unmarshal(raw, &text); err == nil {
+			return parseTimeLiteral(typeName, text)
+		}
+	case "objectid":
+		var value string
+		if err = json.Unmarshal(raw, &value); err == nil {
+			return ObjectIDHex(value), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown literal type '%s'", typeName)
+	}
+	return nil, errors.Wrapf(err, "invalid %s literal", typeName)
+}
+
+// parseTimeLiteral lexes date and time literals so they get the same tokens as in a $filter
+func parseTimeLiteral(typeName, text string) (*ParseNode, error) {
+	expected := map[string]int{"date": filterTokenDate, "datetime": filterTokenDateTime, "time": filterTokenTime}
+	tokens, err := tokenizeFilter(text, globalFilterParser)
+	if err != nil || len(tokens) != 1 || tokens[0].Type != expected[typeName] {
+		return nil, fmt.Errorf("invalid %s literal '%s'", typeName, text)
+	}
+	tokens[0].pos = 0
+	return &ParseNode{tokens[0], nil, make([]*ParseNode, 0)}, nil
+}
+
+// Validate checks the structure of a filter tree built in Go or decoded from JSON:
+// operators and functions have the right operands and the root is a boolean expression
+func Validate(node *ParseNode) error {
+	if node == nil || node.Token == nil {
+		return errors.New("filter cannot be empty")
+	}
+	if err := validateTree(node); err != nil {
+		return err
+	}
+	if !isPredicate(node) {
+		return errors.New("filter must be a boolean expression")
+	}
+	return nil
+}
+
+func validateTree(node *ParseNode) error {
+	for _, child := range node.Children {
+		if child == nil {
+			return errors.New("filter cannot contain empty nodes")
+		}
+		if err := validateTree(child); err != nil {
+			return err
+		}
+	}
+	return validateNode(node)
+}
+
+// validateNode checks the operands of a single node
+func validateNode(node *ParseNode) error {
+	if node.Token == nil {
+		return errors.New("filter cannot contain empty nodes")
+	}
+	for _, child := range node.Children {
+		if child == nil || child.Token == nil {
+			return errors.New("filter cannot contain empty nodes")
+		}
+	}
+
+	switch node.Token.Type {
+	case filterTokenLogical:
+		o, ok := globalFilterParser.Operators[node.Token.stringValue]
+		if !ok {
+			return fmt.Errorf("unknown operator '%s'", node.Token.stringValue)
+		}
+		count := len(node.Children)
+		switch {
+		case o.Operands == variadic && count < 2,
+			o.Operands == 2 && o.Predicates && count < 2,
+			o.Operands == 2 && !o.Predicates && count != 2,
+			o.Operands == 1 && count != 1:
+			return fmt.Errorf("operator '%s' cannot have %d operands", node.Token.stringValue, count)
+		}
+		return checkOperands(node, o)
+
+	case filterTokenFunc:
+		f, ok := globalFilterParser.Functions[node.Token.stringValue]
+		if !ok {
+			return fmt.Errorf("unknown function '%s'", node.Token.stringValue)
+		}
+		if len(node.Children) != f.Params {
+			return fmt.Errorf("function '%s' expects %d parameters but got %d",
+				node.Token.stringValue, f.Params, len(node.Children))
+		}
+		for _, param := range node.Children {
+			if !isValue(param) {
+				return fmt.Errorf("function '%s' cannot have '%s' as parameter",
+					node.Token.stringValue, param.Token.stringValue)
+			}
+		}
+		return nil
+	}
+
+	if len(node.Children) != 0 {
+		return fmt.Errorf("literal '%s' cannot have children", node.Token.stringValue)
+	}
+	if node.Token.Type == filterTokenLiteral && !isIdentifier(node.Token.stringValue) {
+		return fmt.Errorf("invalid property '%s'", node.Token.stringValue)
+	}
+	if value, ok := node.Token.Value.(ObjectID); ok && !isObjectIDHex(string(value)) {
+		return fmt.Errorf("invalid ObjectId literal '%s'", value)
+	}
+	return nil
+}
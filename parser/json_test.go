@@ -0,0 +1,100 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestQueryJSONRoundTrip(t *testing.T) {
+	query, err := ParseURLValues(url.Values{
+		Filter: {"(name eq 'it''s' or qty ge 2.5) and not contains(code, 'x') and " +
+			"created lt 2019-08-01T10:30:00Z and day eq 2019-08-01 and at gt 10:30 and " +
+			"status in ('a', 'b') and active eq true and count ne -3 and _id gt ObjectId'59a6fbaf22e60174f5107a9a'"},
+		Select:      {"name,qty"},
+		OrderBy:     {"name desc,qty"},
+		Top:         {"10"},
+		Skip:        {"5"},
+		InlineCount: {"allpages"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MarshalQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalQuery(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, decodedFilter := query[Filter].(*ParseNode), decoded[Filter].(*ParseNode)
+	if treeString(filter) != treeString(decodedFilter) || nodeKey(filter) != nodeKey(decodedFilter) {
+		t.Errorf("Expected: %s \tGot: %s", treeString(filter), treeString(decodedFilter))
+	}
+	delete(query, Filter)
+	delete(decoded, Filter)
+	if !reflect.DeepEqual(query, decoded) {
+		t.Errorf("Expected: %v \tGot: %v", query, decoded)
+	}
+}
+
+func TestQueryJSONEncoding(t *testing.T) {
+	query, err := ParseURLValues(url.Values{Filter: {"name eq 'a' and qty gt 1"}, Top: {"10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"version":1,"filter":{"op":"and","args":[` +
+		`{"op":"eq","args":[{"prop":"name"},{"type":"string","value":"a"}]},` +
+		`{"op":"gt","args":[{"prop":"qty"},{"type":"int","value":1}]}]},"top":10}`
+	if string(data) != expected {
+		t.Errorf("Expected: %s \tGot: %s", expected, data)
+	}
+}
+
+func TestUnmarshalQueryInvalid(t *testing.T) {
+	inputs := []string{
+		`{"version":2}`,
+		`{"version":1,"filter":{"prop":"name"}}`,
+		`{"version":1,"filter":{"op":"like","args":[{"prop":"a"},{"prop":"b"}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"a"}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"a"},null]}}`,
+		`{"version":1,"filter":{"op":"and","args":[{"prop":"a"},{"prop":"b"}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"a"},{"type":"int","value":1.5}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"a"},{"type":"date","value":"10:30"}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"a"},{"type":"objectid","value":"59a6"}]}}`,
+		`{"version":1,"filter":{"op":"eq","prop":"a"}}`,
+		`{"version":1,"filter":{"func":"contains","args":[{"prop":"a"}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"$where"},{"type":"int","value":1}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"a b"},{"type":"int","value":1}]}}`,
+		`{"version":1,"filter":{"op":"eq","args":[{"prop":"a' or 1=1 --"},{"type":"int","value":1}]}}`,
+		`{"version":1,"filter":{"func":"contains","args":[{"prop":"a\"b"},{"type":"string","value":"x"}]}}`,
+		`{"version":1,"select":["name","$where"]}`,
+		`{"version":1,"select":["a b"]}`,
+		`{"version":1,"select":["name","*"]}`,
+		`{"version":1,"select":["a'b"]}`,
+		`{"version":1,"orderby":[{"field":"$where","order":"asc"}]}`,
+		`{"version":1,"orderby":[{"field":"a b","order":"asc"}]}`,
+		`{"version":1,"orderby":[{"field":"a\"b","order":"desc"}]}`,
+		`{"version":1,"orderby":[{"field":"a","order":"up"}]}`,
+		`{"version":1,"count":true,"inlinecount":"allpages"}`,
+	}
+	for _, input := range inputs {
+		if _, err := UnmarshalQuery([]byte(input)); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
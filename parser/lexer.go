@@ -0,0 +1,303 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts used to convert date and time literals
+const (
+	dateLayout      = "2006-01-02"
+	dateTimeLayout  = time.RFC3339Nano
+	timeLayout      = "15:04:05.999999999"
+	shortTimeLayout = "15:04"
+)
+
+// lexer scans a $filter expression into tokens in a single pass over the input
+type lexer struct {
+	input  string
+	pos    int
+	parser *Parser
+}
+
+// tokenizeFilter splits the filter expression into tokens. Words are classified
+// as operators or functions using the definitions of the given parser.
+func tokenizeFilter(input string, p *Parser) ([]*Token, error) {
+	l := lexer{input: input, parser: p}
+	result := make([]*Token, 0)
+
+	for {
+		l.skipWhitespace()
+		if l.pos >= len(l.input) {
+			return result, nil
+		}
+		token, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, token)
+	}
+}
+
+// isWhitespace reports whether c is whitespace according to OData (SP and HTAB),
+// line breaks are also accepted for filters spread over multiple lines
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '_' || c == '.'
+}
+
+// isIdentifier reports whether the name is lexed as a single identifier, e.g. a property of a $filter
+func isIdentifier(name string) bool {
+	if name == "" || !(isLetter(name[0]) || name[0] == '_') {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isIdentifierChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *lexer) skipWhitespace() {
+	for l.pos < len(l.input) && isWhitespace(l.input[l.pos]) {
+		l.pos++
+	}
+}
+
+// peek returns the byte at offset n from the current position or 0 at the end of the input
+func (l *lexer) peek(n int) byte {
+	if l.pos+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+n]
+}
+
+// digits returns the number of consecutive digits starting at offset n from the current position
+func (l *lexer) digits(n int) int {
+	count := 0
+	for isDigit(l.peek(n + count)) {
+		count++
+	}
+	return count
+}
+
+func (l *lexer) next() (*Token, error) {
+	c := l.input[l.pos]
+	switch {
+	case c == '(':
+		return l.emit(l.pos+1, filterTokenOpenParen, "(")
+	case c == ')':
+		return l.emit(l.pos+1, filterTokenCloseParen, ")")
+	case c == ',':
+		return l.emit(l.pos+1, filterTokenComma, ",")
+	case c == '\'':
+		return l.scanString()
+	case isDigit(c) || (c == '-' && isDigit(l.peek(1))):
+		return l.scanNumber()
+	case isLetter(c) || c == '_':
+		return l.scanWord()
+	}
+	return nil, fmt.Errorf("parse error: unexpected character %q at position %d", c, l.pos)
+}
+
+// emit creates a token from the current position up to end and advances the lexer
+func (l *lexer) emit(end int, tokenType int, value interface{}) (*Token, error) {
+	token := &Token{stringValue: l.input[l.pos:end], Value: value, Type: tokenType, pos: l.pos}
+	l.pos = end
+	return token, nil
+}
+
+// scanString scans a single quoted string, two consecutive quotes are an escaped quote
+func (l *lexer) scanString() (*Token, error) {
+	end := l.pos + 1
+	for end < len(l.input) {
+		if l.input[end] == '\'' {
+			if end+1 < len(l.input) && l.input[end+1] == '\'' {
+				end += 2
+				continue
+			}
+			return l.emit(end+1, filterTokenString, l.input[l.pos:end+1])
+		}
+		end++
+	}
+	return nil, fmt.Errorf("parse error: unterminated string literal at position %d", l.pos)
+}
+
+// scanWord scans identifiers and classifies them as operators, functions, booleans or literals
+func (l *lexer) scanWord() (*Token, error) {
+	end := l.pos + 1
+	for end < len(l.input) && isIdentifierChar(l.input[end]) {
+		end++
+	}
+	word := l.input[l.pos:end]
+	if end < len(l.input) && l.input[end] == '\'' && strings.EqualFold(word, "ObjectId") {
+		return l.scanObjectID(end)
+	}
+
+	if _, ok := l.parser.Operators[word]; ok {
+		return l.emit(end, filterTokenLogical, word)
+	}
+	if _, ok := l.parser.Functions[word]; ok {
+		return l.emit(end, filterTokenFunc, word)
+	}
+	if strings.EqualFold(word, "true") || strings.EqualFold(word, "false") {
+		return l.emit(end, filterTokenBoolean, strings.EqualFold(word, "true"))
+	}
+	return l.emit(end, filterTokenLiteral, word)
+}
+
+// scanObjectID scans an ObjectId'<24 hex digits>' literal, quote is the position of the opening quote
+func (l *lexer) scanObjectID(quote int) (*Token, error) {
+	end := quote + 1 + objectIDLength
+	if end >= len(l.input) || l.input[end] != '\'' || !isObjectIDHex(l.input[quote+1:end]) {
+		return nil, fmt.Errorf("parse error: invalid ObjectId literal at position %d", l.pos)
+	}
+	return l.emit(end+1, filterTokenObjectID, ObjectID(strings.ToLower(l.input[quote+1:end])))
+}
+
+// isObjectIDHex reports whether value is the hex representation of an ObjectId
+func isObjectIDHex(value string) bool {
+	if len(value) != objectIDLength {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// scanNumber scans integers, floats, dates, times and date times
+func (l *lexer) scanNumber() (*Token, error) {
+	start := 0
+	if l.peek(0) == '-' {
+		start = 1
+	}
+	intDigits := l.digits(start)
+	end := start + intDigits
+
+	switch {
+	case start == 0 && intDigits == 4 && l.peek(4) == '-' && l.digits(5) == 2 &&
+		l.peek(7) == '-' && l.digits(8) == 2:
+		return l.scanDate()
+	case start == 0 && intDigits == 2 && l.peek(2) == ':':
+		return l.scanTime()
+	}
+
+	tokenType := filterTokenInteger
+	if l.peek(end) == '.' && isDigit(l.peek(end+1)) {
+		tokenType = filterTokenFloat
+		end += 1 + l.digits(end+1)
+	}
+	if c := l.peek(end); c == 'e' || c == 'E' {
+		exp := end + 1
+		if c := l.peek(exp); c == '+' || c == '-' {
+			exp++
+		}
+		if l.digits(exp) > 0 {
+			tokenType = filterTokenFloat
+			end = exp + l.digits(exp)
+		}
+	}
+	if isIdentifierChar(l.peek(end)) {
+		return nil, fmt.Errorf("parse error: invalid numeric literal %q at position %d",
+			l.input[l.pos:l.pos+end+1], l.pos)
+	}
+
+	text := l.input[l.pos : l.pos+end]
+	if tokenType == filterTokenFloat {
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse error: invalid float literal %q at position %d", text, l.pos)
+		}
+		return l.emit(l.pos+end, tokenType, value)
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: invalid integer literal %q at position %d", text, l.pos)
+	}
+	return l.emit(l.pos+end, tokenType, value)
+}
+
+// scanDate scans a date (yyyy-mm-dd) optionally followed by a time and a zone offset
+func (l *lexer) scanDate() (*Token, error) {
+	end := 10
+	if l.peek(end) != 'T' {
+		text := l.input[l.pos : l.pos+end]
+		value, err := time.Parse(dateLayout, text)
+		if err != nil {
+			return nil, fmt.Errorf("parse error: invalid date literal %q at position %d", text, l.pos)
+		}
+		return l.emit(l.pos+end, filterTokenDate, value)
+	}
+
+	end = l.timeEnd(end + 1)
+	switch l.peek(end) {
+	case 'Z':
+		end++
+	case '+', '-':
+		if l.digits(end+1) == 2 && l.peek(end+3) == ':' && l.digits(end+4) == 2 {
+			end += 6
+		}
+	}
+	text := l.input[l.pos : l.pos+end]
+	value, err := time.Parse(dateTimeLayout, text)
+	if err != nil {
+		// seconds are optional in OData
+		value, err = time.Parse("2006-01-02T15:04Z07:00", text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse error: invalid datetime literal %q at position %d", text, l.pos)
+	}
+	return l.emit(l.pos+end, filterTokenDateTime, value)
+}
+
+// scanTime scans a time of day (hh:mm[:ss[.fff]])
+func (l *lexer) scanTime() (*Token, error) {
+	end := l.timeEnd(0)
+	text := l.input[l.pos : l.pos+end]
+	layout := timeLayout
+	if len(text) == len(shortTimeLayout) {
+		layout = shortTimeLayout
+	}
+	value, err := time.Parse(layout, text)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: invalid time literal %q at position %d", text, l.pos)
+	}
+	return l.emit(l.pos+end, filterTokenTime, value)
+}
+
+// timeEnd returns the offset of the end of a time of day starting at offset n
+func (l *lexer) timeEnd(n int) int {
+	end := n + l.digits(n)
+	if l.peek(end) != ':' {
+		return end
+	}
+	end += 1 + l.digits(end+1)
+	if l.peek(end) != ':' {
+		return end
+	}
+	end += 1 + l.digits(end+1)
+	if l.peek(end) == '.' && isDigit(l.peek(end+1)) {
+		end += 1 + l.digits(end+1)
+	}
+	return end
+}
@@ -0,0 +1,205 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// legacyTokenizer is the regular expression based tokenizer the lexer replaced.
+// It is kept to compare token streams and performance.
+type legacyTokenizer struct {
+	matchers []*legacyMatcher
+	ignore   *regexp.Regexp
+}
+
+type legacyMatcher struct {
+	re    *regexp.Regexp
+	token int
+}
+
+func newLegacyTokenizer() *legacyTokenizer {
+	t := legacyTokenizer{ignore: regexp.MustCompile("^ ")}
+	add := func(pattern string, token int) {
+		t.matchers = append(t.matchers, &legacyMatcher{regexp.MustCompile(pattern), token})
+	}
+	add("^\\(", filterTokenOpenParen)
+	add("^\\)", filterTokenCloseParen)
+	add("^,", filterTokenComma)
+	add("^(eq|ne|gt|ge|lt|le|and|or) ", filterTokenLogical)
+	add("^(contains|endswith|startswith)", filterTokenFunc)
+	add("^-?[0-9]+\\.[0-9]+", filterTokenFloat)
+	add("^-?[0-9]+", filterTokenInteger)
+	add("^(?i:true|false)", filterTokenBoolean)
+	add("^'(''|[^'])*'", filterTokenString)
+	add("^[a-zA-Z][a-zA-Z0-9_.]*", filterTokenLiteral)
+	add("^_id", filterTokenLiteral)
+	return &t
+}
+
+func (t *legacyTokenizer) tokenize(input string) []*Token {
+	target := []byte(input)
+	result := make([]*Token, 0)
+	match := true
+	for len(target) > 0 && match {
+		match = false
+		for _, m := range t.matchers {
+			token := m.re.Find(target)
+			if len(token) > 0 {
+				text := strings.TrimSpace(string(token))
+				var value interface{} = text
+				switch m.token {
+				case filterTokenInteger:
+					value, _ = strconv.Atoi(text)
+				case filterTokenFloat:
+					value, _ = strconv.ParseFloat(text, 64)
+				case filterTokenBoolean:
+					value, _ = strconv.ParseBool(text)
+				}
+				result = append(result, &Token{stringValue: text, Value: value, Type: m.token})
+				target = target[len(token):]
+				match = true
+				break
+			}
+		}
+		if token := t.ignore.Find(target); len(token) > 0 {
+			target = target[len(token):]
+			match = true
+		}
+	}
+	return result
+}
+
+const benchmarkFilter = "((epc_item_type gt 0) and (event ne 'departed') and (required eq true) and (count lt 0.1) or " +
+	"(SKU eq '123') and contains(epc_time, '0') and startswith(epc_code, '456') or " +
+	"endswith(upc_code, '789')) and _id gt '59a6fbaf22e60174f5107a9a' and gtin eq '123'"
+
+func TestLexerMatchesLegacyTokenizer(t *testing.T) {
+	inputs := []string{
+		benchmarkFilter,
+		"_id gt '59a6fbaf22e60174f5107a9a' and upc_code eq 'val'",
+		"gtin eq '123'",
+		"name eq 'val')",
+		"(name eq )",
+		"(name eq hello) and (name fakeop hello)",
+		"epc_item_type ne 0 and name",
+		"name eqs epc_item_type",
+		"price gt 20 or price lt -10 or price ge 30.5 or price le 50",
+		"name eq 'it''s'",
+	}
+
+	legacy := newLegacyTokenizer()
+	for _, input := range inputs {
+		tokens, err := tokenizeFilter(input, globalFilterParser)
+		if err != nil {
+			t.Fatalf("%s: %s", input, err)
+		}
+		expected := legacy.tokenize(input)
+		if len(tokens) != len(expected) {
+			t.Fatalf("%s: expected %d tokens, got %d", input, len(expected), len(tokens))
+		}
+		for i := range tokens {
+			if tokens[i].stringValue != expected[i].stringValue ||
+				tokens[i].Type != expected[i].Type ||
+				tokens[i].Value != expected[i].Value {
+				t.Errorf("%s: token %d expected %+v, got %+v", input, i, expected[i], tokens[i])
+			}
+		}
+	}
+}
+
+func TestLexerLiterals(t *testing.T) {
+	var lexTests = []struct {
+		input     string
+		tokenType int
+		value     interface{}
+	}{
+		{"42", filterTokenInteger, 42},
+		{"-7", filterTokenInteger, -7},
+		{"1.5", filterTokenFloat, 1.5},
+		{"2e3", filterTokenFloat, 2000.0},
+		{"TRUE", filterTokenBoolean, true},
+		{"false", filterTokenBoolean, false},
+		{"'a''b'", filterTokenString, "'a''b'"},
+		{"_id", filterTokenLiteral, "_id"},
+		{"tag.epc", filterTokenLiteral, "tag.epc"},
+		{"2019-08-01", filterTokenDate, time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"2019-08-01T10:30:00Z", filterTokenDateTime, time.Date(2019, 8, 1, 10, 30, 0, 0, time.UTC)},
+		{"2019-08-01T10:30Z", filterTokenDateTime, time.Date(2019, 8, 1, 10, 30, 0, 0, time.UTC)},
+		{"10:30:15", filterTokenTime, time.Date(0, 1, 1, 10, 30, 15, 0, time.UTC)},
+		{"ObjectId'59A6fbaf22e60174f5107a9a'", filterTokenObjectID, ObjectID("59a6fbaf22e60174f5107a9a")},
+	}
+
+	for _, test := range lexTests {
+		tokens, err := tokenizeFilter(test.input, globalFilterParser)
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		if len(tokens) != 1 {
+			t.Errorf("%s: expected a single token, got %d", test.input, len(tokens))
+			continue
+		}
+		if tokens[0].Type != test.tokenType || !reflect.DeepEqual(tokens[0].Value, test.value) {
+			t.Errorf("%s: expected type %d value %v, got type %d value %v",
+				test.input, test.tokenType, test.value, tokens[0].Type, tokens[0].Value)
+		}
+	}
+}
+
+func TestLexerWhitespaceAndOperators(t *testing.T) {
+	tokens, err := tokenizeFilter("a\teq(\n1)and\r\nb  ne 2", globalFilterParser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, token := range tokens {
+		values = append(values, token.stringValue)
+	}
+	expected := []string{"a", "eq", "(", "1", ")", "and", "b", "ne", "2"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	inputs := []string{
+		"name eq 'unterminated",
+		"count eq 99999999999999999999",
+		"count eq 12abc",
+		"name eq #",
+		"created gt 2019-13-45",
+		"_id eq ObjectId'59a6fbaf22e60174f5107a9'",
+		"_id eq ObjectId'59a6fbaf22e60174f5107a9z'",
+	}
+	for _, input := range inputs {
+		if _, err := tokenizeFilter(input, globalFilterParser); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func BenchmarkLexer(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := tokenizeFilter(benchmarkFilter, globalFilterParser); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLegacyTokenizer(b *testing.B) {
+	legacy := newLegacyTokenizer()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		legacy.tokenize(benchmarkFilter)
+	}
+}
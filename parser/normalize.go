@@ -0,0 +1,335 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Normalize returns a simplified copy of the filter tree which selects the same records:
+//  - nested and/or chains are flattened into nodes with any number of children
+//  - constant comparisons and functions are folded into true/false
+//  - duplicate predicates of an and/or are removed
+//  - equalities on the same property joined by or are rewritten into in
+//  - not is pushed down to the comparisons using De Morgan's laws
+// Boolean literals only remain when the whole filter folds into true or false.
+func Normalize(node *ParseNode) *ParseNode {
+	if node == nil || node.Token == nil {
+		return node
+	}
+	return normalize(node)
+}
+
+func normalize(node *ParseNode) *ParseNode {
+	switch operatorOf(node) {
+	case "not":
+		return negate(node.Children[0])
+	case "and", "or":
+		return normalizeLogical(node)
+	}
+	return foldConstant(node.Clone())
+}
+
+// negate returns the normalized negation of the node
+func negate(node *ParseNode) *ParseNode {
+	switch operator := operatorOf(node); operator {
+	case "not":
+		return normalize(node.Children[0])
+
+	case "and", "or":
+		opposite := "or"
+		if operator == "or" {
+			opposite = "and"
+		}
+		children := make([]*ParseNode, len(node.Children))
+		for i, child := range node.Children {
+			children[i] = &ParseNode{operatorToken("not"), nil, []*ParseNode{child}}
+		}
+		return normalize(&ParseNode{operatorToken(opposite), nil, children})
+
+	case "eq", "ne":
+		opposite := "ne"
+		if operator == "ne" {
+			opposite = "eq"
+		}
+		flipped := node.Clone()
+		flipped.Token = operatorToken(opposite)
+		return foldConstant(flipped)
+	}
+
+	child := normalize(node)
+	if value, ok := booleanValue(child); ok {
+		return booleanNode(!value)
+	}
+	return &ParseNode{operatorToken("not"), nil, []*ParseNode{child}}
+}
+
+// normalizeLogical flattens, folds and deduplicates the children of an and/or node
+func normalizeLogical(node *ParseNode) *ParseNode {
+	operator := operatorOf(node)
+	isAnd := operator == "and"
+
+	children := make([]*ParseNode, 0, len(node.Children))
+	seen := make(map[string]bool)
+	for _, child := range node.Children {
+		child = normalize(child)
+		candidates := []*ParseNode{child}
+		if operatorOf(child) == operator {
+			candidates = child.Children
+		}
+
+		for _, candidate := range candidates {
+			if value, ok := booleanValue(candidate); ok {
+				if value == isAnd {
+					// true in an and, false in an or has no effect
+					continue
+				}
+				return booleanNode(!isAnd)
+			}
+			key := nodeKey(candidate)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			children = append(children, candidate)
+		}
+	}
+
+	if !isAnd {
+		children = mergeEqualities(children)
+	}
+
+	switch len(children) {
+	case 0:
+		return booleanNode(isAnd)
+	case 1:
+		return children[0]
+	}
+	return &ParseNode{operatorToken(operator), nil, children}
+}
+
+// mergeEqualities rewrites equalities and in lists on the same property into a single in
+func mergeEqualities(children []*ParseNode) []*ParseNode {
+	values := make(map[string][]*ParseNode)
+	count := make(map[string]int)
+	for _, child := range children {
+		if property, ok := equalityProperty(child); ok {
+			values[property] = append(values[property], child.Children[1:]...)
+			count[property]++
+		}
+	}
+
+	result := make([]*ParseNode, 0, len(children))
+	merged := make(map[string]bool)
+	for _, child := range children {
+		property, ok := equalityProperty(child)
+		if !ok || count[property] < 2 {
+			result = append(result, child)
+			continue
+		}
+		if merged[property] {
+			continue
+		}
+		merged[property] = true
+
+		node := &ParseNode{operatorToken("in"), nil, []*ParseNode{child.Children[0]}}
+		seen := make(map[string]bool)
+		for _, value := range values[property] {
+			if key := nodeKey(value); !seen[key] {
+				seen[key] = true
+				node.Children = append(node.Children, value)
+			}
+		}
+		if len(node.Children) == 2 {
+			node.Token = operatorToken("eq")
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// equalityProperty returns the property of an eq or in node comparing a property with constants
+func equalityProperty(node *ParseNode) (string, bool) {
+	operator := operatorOf(node)
+	if operator != "eq" && operator != "in" {
+		return "", false
+	}
+	if node.Children[0].Token.Type != filterTokenLiteral {
+		return "", false
+	}
+	for _, child := range node.Children[1:] {
+		if !isConstant(child) {
+			return "", false
+		}
+	}
+	return node.Children[0].Token.stringValue, true
+}
+
+// foldConstant evaluates comparisons and functions whose operands are all constants
+func foldConstant(node *ParseNode) *ParseNode {
+	if len(node.Children) != 2 || !isConstant(node.Children[0]) || !isConstant(node.Children[1]) {
+		return node
+	}
+	left := constantValue(node.Children[0])
+	right := constantValue(node.Children[1])
+
+	switch node.Token.stringValue {
+	case "contains", "startswith", "endswith":
+		l, leftOk := left.(string)
+		r, rightOk := right.(string)
+		if !leftOk || !rightOk {
+			return node
+		}
+		switch node.Token.stringValue {
+		case "contains":
+			return booleanNode(strings.Contains(l, r))
+		case "startswith":
+			return booleanNode(strings.HasPrefix(l, r))
+		}
+		return booleanNode(strings.HasSuffix(l, r))
+	}
+
+	cmp, ok := compareConstants(left, right)
+	if !ok {
+		return node
+	}
+	switch node.Token.stringValue {
+	case "eq":
+		return booleanNode(cmp == 0)
+	case "ne":
+		return booleanNode(cmp != 0)
+	}
+	if _, isBool := left.(bool); isBool {
+		// booleans are not ordered
+		return node
+	}
+	switch node.Token.stringValue {
+	case "gt":
+		return booleanNode(cmp > 0)
+	case "ge":
+		return booleanNode(cmp >= 0)
+	case "lt":
+		return booleanNode(cmp < 0)
+	case "le":
+		return booleanNode(cmp <= 0)
+	}
+	return node
+}
+
+// compareConstants compares two constants of compatible types
+func compareConstants(left, right interface{}) (int, bool) {
+	if l, ok := toFloat(left); ok {
+		if r, ok := toFloat(right); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), true
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			if l == r {
+				return 0, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			switch {
+			case l.Before(r):
+				return -1, true
+			case l.After(r):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// constantValue returns the value of a constant, string literals are unquoted
+func constantValue(node *ParseNode) interface{} {
+	if node.Token.Type == filterTokenString {
+		return unquote(node.Token.stringValue)
+	}
+	return node.Token.Value
+}
+
+// unquote removes the quotes of a string literal and unescapes doubled quotes
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = value[1 : len(value)-1]
+	}
+	return strings.Replace(value, "''", "'", -1)
+}
+
+// isConstant reports whether the node is a literal value rather than a property or expression
+func isConstant(node *ParseNode) bool {
+	return isValue(node) && node.Token.Type != filterTokenLiteral
+}
+
+func operatorOf(node *ParseNode) string {
+	if node.Token == nil || node.Token.Type != filterTokenLogical {
+		return ""
+	}
+	return node.Token.stringValue
+}
+
+func booleanValue(node *ParseNode) (bool, bool) {
+	if node.Token == nil || node.Token.Type != filterTokenBoolean || len(node.Children) != 0 {
+		return false, false
+	}
+	value, ok := node.Token.Value.(bool)
+	return value, ok
+}
+
+func operatorToken(operator string) *Token {
+	return &Token{stringValue: operator, Value: operator, Type: filterTokenLogical}
+}
+
+func booleanNode(value bool) *ParseNode {
+	token := &Token{stringValue: fmt.Sprint(value), Value: value, Type: filterTokenBoolean}
+	return &ParseNode{token, nil, make([]*ParseNode, 0)}
+}
+
+// nodeKey returns a string identifying the structure and values of the tree
+func nodeKey(node *ParseNode) string {
+	var key strings.Builder
+	writeNodeKey(&key, node)
+	return key.String()
+}
+
+func writeNodeKey(key *strings.Builder, node *ParseNode) {
+	fmt.Fprintf(key, "(%d:%v", node.Token.Type, node.Token.Value)
+	for _, child := range node.Children {
+		key.WriteByte(' ')
+		writeNodeKey(key, child)
+	}
+	key.WriteByte(')')
+}
@@ -0,0 +1,56 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	var normalizeTests = []struct {
+		input    string
+		expected string
+	}{
+		// flattening
+		{"a eq 1 and (b eq 2 and (c eq 3 and d eq 4))", "(and (eq a 1) (eq b 2) (eq c 3) (eq d 4))"},
+		{"(a gt 1 or b gt 2) or c gt 3", "(or (gt a 1) (gt b 2) (gt c 3))"},
+		{"(a gt 1 or b gt 2) and c gt 3", "(and (or (gt a 1) (gt b 2)) (gt c 3))"},
+		// constant folding
+		{"a eq 1 and true", "(eq a 1)"},
+		{"a eq 1 and 1 eq 2", "false"},
+		{"a eq 1 or 2 gt 1.5", "true"},
+		{"a eq 1 or contains('abc', 'b')", "true"},
+		{"a eq 1 and 2019-01-01 lt 2019-02-01", "(eq a 1)"},
+		{"not ('a' eq 'a') or a eq 1", "(eq a 1)"},
+		// duplicates
+		{"a eq 1 and b eq 2 and a eq 1", "(and (eq a 1) (eq b 2))"},
+		{"(a eq 1 or b eq 2) and (a eq 1 or b eq 2)", "(or (eq a 1) (eq b 2))"},
+		// equalities into in
+		{"a eq 'x' or a eq 'y' or b eq 1 or a eq 'x'", "(or (in a 'x' 'y') (eq b 1))"},
+		{"a in ('x', 'y') or a eq 'z'", "(in a 'x' 'y' 'z')"},
+		{"a eq b or a eq 'z'", "(or (eq a b) (eq a 'z'))"},
+		// De Morgan
+		{"not (a eq 1 and b gt 2)", "(or (ne a 1) (not (gt b 2)))"},
+		{"not (a eq 1 or not (b ne 2))", "(and (ne a 1) (ne b 2))"},
+		{"not not contains(a, 'x')", "(contains a 'x')"},
+	}
+
+	for _, test := range normalizeTests {
+		tree, err := parseFilterString(test.input)
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		before := treeString(tree)
+		if result := treeString(Normalize(tree)); result != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, result)
+		}
+		if treeString(tree) != before {
+			t.Errorf("%s: input tree was modified", test.input)
+		}
+	}
+}
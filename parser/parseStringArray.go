@@ -15,8 +15,8 @@ import (
 
 // OrderItem holds order key information
 type OrderItem struct {
-	Field string
-	Order string
+	Field string `json:"field"`
+	Order string `json:"order"`
 }
 
 func parseStringArray(value *string) ([]string, error) {
@@ -34,6 +34,37 @@ func parseStringArray(value *string) ([]string, error) {
 	return result, nil
 }
 
+// parseSelectArray parses the fields of $select
+func parseSelectArray(value *string) ([]string, error) {
+	fields, err := parseStringArray(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSelect(fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// validateSelect checks that the selected fields are neither empty nor repeated,
+// and that * is not mixed with other fields
+func validateSelect(fields []string) error {
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if field == "" {
+			return errors.New("select cannot contain empty fields")
+		}
+		if field == "*" && len(fields) > 1 {
+			return errors.New("select cannot mix * with other fields")
+		}
+		if seen[field] {
+			return errors.New("Duplicate field '" + field + "' in select")
+		}
+		seen[field] = true
+	}
+	return nil
+}
+
 func parseOrderArray(value *string) ([]OrderItem, error) {
 	parsedArray, err := parseStringArray(value)
 	if err != nil {
@@ -8,10 +8,8 @@ package parser
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
-	"strconv"
-	"strings"
-	"time"
 )
 
 // Operator constants
@@ -20,13 +18,22 @@ const (
 	opAssociationRight
 )
 
+// variadic is the number of operands of an operator taking a parenthesized list of values
+const variadic = -1
+
+var errUnexpectedEnd = errors.New("parse error: unexpected end of filter")
+
 // Tokenizer structure
+//
+// Deprecated: filters are tokenized by a hand-written lexer, the parser no longer uses this type.
 type Tokenizer struct {
 	TokenMatchers  []*TokenMatcher
 	IgnoreMatchers []*TokenMatcher
 }
 
 // TokenMatcher token matcher structure
+//
+// Deprecated: filters are tokenized by a hand-written lexer, the parser no longer uses this type.
 type TokenMatcher struct {
 	Pattern string
 	Re      *regexp.Regexp
@@ -38,76 +45,8 @@ type Token struct {
 	stringValue string
 	Value       interface{}
 	Type        int
-}
-
-// Add adds token to the tokenizer
-func (t *Tokenizer) add(pattern string, token int) {
-	rxp := regexp.MustCompile(pattern)
-	matcher := &TokenMatcher{pattern, rxp, token}
-	t.TokenMatchers = append(t.TokenMatchers, matcher)
-}
-
-// Ignore adds ignore case to the tokenizer
-func (t *Tokenizer) ignore(pattern string, token int) {
-	rxp := regexp.MustCompile(pattern)
-	matcher := &TokenMatcher{pattern, rxp, token}
-	t.IgnoreMatchers = append(t.IgnoreMatchers, matcher)
-}
-
-// TokenizeBytes tokenizes the bytes
-func (t *Tokenizer) tokenizeBytes(target []byte) ([]*Token, error) {
-	result := make([]*Token, 0)
-	match := true // false when no match is found
-	for len(target) > 0 && match {
-		match = false
-		for _, m := range t.TokenMatchers {
-			token := m.Re.Find(target)
-			if len(token) > 0 {
-				convValue, _ := convertValue(token, m.Token)
-				parsed := Token{stringValue: strings.TrimSpace(string(token)), Value: convValue, Type: m.Token}
-				result = append(result, &parsed)
-				target = target[len(token):] // remove the token from the input
-				match = true
-				break
-			}
-		}
-		for _, m := range t.IgnoreMatchers {
-			token := m.Re.Find(target)
-			if len(token) > 0 {
-				match = true
-				target = target[len(token):] // remove the token from the input
-				break
-			}
-		}
-	}
-
-	if len(target) > 0 && !match {
-		return result, errors.New("No matching token for " + string(target))
-	}
-
-	return result, nil
-}
-
-func convertValue(token []byte, tokenType int) (interface{}, error) {
-	switch tokenType {
-	case filterTokenInteger:
-		return strconv.Atoi(string(token))
-	case filterTokenBoolean:
-		return strconv.ParseBool(string(token))
-	case filterTokenFloat:
-		return strconv.ParseFloat(string(token), 10)
-	case filterTokenLiteral, filterTokenString:
-		return strings.TrimSpace(string(token)), nil
-	case filterTokenDateTime, filterTokenDate, filterTokenTime:
-		return time.Parse(time.RFC1123, string(token))
-	default:
-		return strings.TrimSpace(string(token)), nil
-	}
-}
-
-// Tokenize tokenize string by converting it to bytes and passing the array to the tokeizeBytes function
-func (t *Tokenizer) tokenize(target string) ([]*Token, error) {
-	return t.tokenizeBytes([]byte(target))
+	// offset of the token in the filter expression
+	pos int
 }
 
 // Parser parser structure
@@ -127,6 +66,8 @@ type Operator struct {
 	Operands int
 	// Rank of precedence
 	Precedence int
+	// Whether the operands are boolean expressions rather than values
+	Predicates bool
 }
 
 // Function function structure
@@ -143,6 +84,25 @@ type ParseNode struct {
 	Children []*ParseNode
 }
 
+// Clone returns a deep copy of the node and its children
+func (n *ParseNode) Clone() *ParseNode {
+	if n == nil {
+		return nil
+	}
+	clone := &ParseNode{Parent: n.Parent, Children: make([]*ParseNode, len(n.Children))}
+	if n.Token != nil {
+		token := *n.Token
+		clone.Token = &token
+	}
+	for i, child := range n.Children {
+		clone.Children[i] = child.Clone()
+		if clone.Children[i].Parent == n {
+			clone.Children[i].Parent = clone
+		}
+	}
+	return clone
+}
+
 // EmptyParser create empty parser
 func emptyParser() *Parser {
 	return &Parser{make(map[string]*Operator), make(map[string]*Function)}
@@ -151,7 +111,12 @@ func emptyParser() *Parser {
 // DefineOperator Adds an operator to the language. Provide the token, a precedence, and
 // whether the operator is left, right, or not associative.
 func (p *Parser) defineOperator(token string, operands, assoc, precedence int) {
-	p.Operators[token] = &Operator{token, assoc, operands, precedence}
+	p.Operators[token] = &Operator{token, assoc, operands, precedence, false}
+}
+
+// DefineLogicalOperator Adds an operator combining boolean expressions, such as and/or/not
+func (p *Parser) defineLogicalOperator(token string, operands, assoc, precedence int) {
+	p.Operators[token] = &Operator{token, assoc, operands, precedence, true}
 }
 
 // DefineFunction Adds a function to the language
@@ -159,291 +124,243 @@ func (p *Parser) defineFunction(token string, params int) {
 	p.Functions[token] = &Function{token, params}
 }
 
-// InfixToPostfix Parses the input string of tokens using the given definitions of operators
-// and functions. (Everything else is assumed to be a literal.) Uses the
-// Shunting-Yard algorithm.
-//nolint :gocyclo
-func (p *Parser) infixToPostfix(tokens []*Token) (*tokenQueue, error) {
-	queue := tokenQueue{}
-	stack := tokenStack{}
-	wasLiteral := false // We use this bool to see if the last token was a literal
-
-	for len(tokens) > 0 {
-		token := tokens[0]
-		tokens = tokens[1:]
-
-		if _, ok := p.Functions[token.stringValue]; ok {
-			// push functions onto the stack
-			stack.push(token)
-			wasLiteral = false
-		} else if token.stringValue == "," {
-			// function parameter separator, pop off stack until we see a "("
-			for stack.peek().stringValue != "(" || stack.empty() {
-				queue.enqueue(stack.pop())
-			}
-			// there was an error parsing
-			if stack.empty() {
-				return nil, errors.New("Parse error")
-			}
-			wasLiteral = false
-		} else if o1, ok := p.Operators[token.stringValue]; ok {
-			// push operators onto stack according to precedence
-			if !stack.empty() {
-				for o2, ok := p.Operators[stack.peek().stringValue]; ok &&
-					(o1.Association == opAssociationLeft && o1.Precedence <= o2.Precedence) ||
-					(o1.Association == opAssociationRight && o1.Precedence < o2.Precedence); {
-					queue.enqueue(stack.pop())
-
-					if stack.empty() {
-						break
-					}
-					o2, ok = p.Operators[stack.peek().stringValue]
-				}
-			}
-			stack.push(token)
-			wasLiteral = false
-		} else if token.stringValue == "(" {
-			// push open parens onto the stack
-			stack.push(token)
-			wasLiteral = false
-		} else if token.stringValue == ")" {
-			// if we find a close paren, pop things off the stack
-			for !stack.empty() && stack.peek().stringValue != "(" {
-				queue.enqueue(stack.pop())
-			}
-			// there was an error parsing
-			if stack.empty() {
-				return nil, errors.New("parse error: mismatched parenthesis")
-			}
-			// pop off open paren
-			stack.pop()
-			// if next token is a function, move it to the queue
-			if !stack.empty() {
-				if _, ok := p.Functions[stack.peek().stringValue]; ok {
-					queue.enqueue(stack.pop())
-				}
-			}
-			wasLiteral = false
-		} else {
-			// if the last token was a literal it means we are trying to push 2 literals into the queue back to back
-			// This will cause issues in the tree parsing. This is a rules violation and will throw an error
-			if wasLiteral {
-				return nil, errors.New("parse error: two literals found in a row")
-			}
-			// Token is a literal -- put it in the queue and set the bool to true
-			queue.enqueue(token)
-			wasLiteral = true
-		}
+// tokenReader walks through the tokens of a filter expression
+type tokenReader struct {
+	tokens []*Token
+	pos    int
+}
+
+func (r *tokenReader) peek() *Token {
+	if r.pos >= len(r.tokens) {
+		return nil
 	}
+	return r.tokens[r.pos]
+}
 
-	// pop off the remaining operators onto the queue
-	for !stack.empty() {
-		if stack.peek().stringValue == "(" || stack.peek().stringValue == ")" {
-			return nil, errors.New("parse error: mismatched parenthesis")
-		}
-		queue.enqueue(stack.pop())
+func (r *tokenReader) next() *Token {
+	token := r.peek()
+	if token != nil {
+		r.pos++
+	}
+	return token
+}
+
+// expect consumes the next token and fails if its type does not match
+func (r *tokenReader) expect(tokenType int, expected string) (*Token, error) {
+	token := r.next()
+	if token == nil {
+		return nil, fmt.Errorf("parse error: expected '%s' but reached the end of filter", expected)
+	}
+	if token.Type != tokenType {
+		return nil, fmt.Errorf("parse error: expected '%s' but found '%s' at position %d",
+			expected, token.stringValue, token.pos)
 	}
+	return token, nil
+}
 
-	return &queue, nil
+func unexpectedToken(token *Token) error {
+	return fmt.Errorf("parse error: unexpected '%s' at position %d", token.stringValue, token.pos)
 }
 
-// PostfixToTree Converts a Postfix token queue to a parse tree
-//nolint :gocyclo
-func (p *Parser) postfixToTree(queue *tokenQueue) (*ParseNode, error) {
-	stack := &nodeStack{}
-	currNode := &ParseNode{}
+// Parse Builds a parse tree from the tokens using the given definitions of operators
+// and functions. (Everything else is assumed to be a literal.) Uses precedence climbing
+// so the tree is built in a single pass.
+func (p *Parser) parse(tokens []*Token) (*ParseNode, error) {
+	r := &tokenReader{tokens: tokens}
+	node, err := p.parseExpression(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if token := r.peek(); token != nil {
+		return nil, unexpectedToken(token)
+	}
+	if !isPredicate(node) {
+		return nil, errors.New("parse error: filter must be a boolean expression")
+	}
+	return node, nil
+}
 
-	t := queue.Head
-	for t != nil {
-		t = t.Next
+// parseExpression parses operands joined by binary operators whose precedence is at least minPrecedence
+func (p *Parser) parseExpression(r *tokenReader, minPrecedence int) (*ParseNode, error) {
+	left, err := p.parsePrimary(r)
+	if err != nil {
+		return nil, err
 	}
 
-	for !queue.empty() {
-		// push the token onto the stack as a tree node
-		currNode = &ParseNode{queue.dequeue(), nil, make([]*ParseNode, 0)}
-		stack.push(currNode)
+	for {
+		token := r.peek()
+		if token == nil || token.Type != filterTokenLogical {
+			return left, nil
+		}
+		o := p.Operators[token.stringValue]
+		if o.Operands == 1 || o.Precedence < minPrecedence {
+			return left, nil
+		}
+		r.next()
 
-		if _, ok := p.Functions[stack.peek().Token.stringValue]; ok {
-			// if the top of the stack is a function
-			node, err := stack.pop()
+		node := &ParseNode{token, nil, []*ParseNode{left}}
+		if o.Operands == variadic {
+			values, err := p.parseList(r)
 			if err != nil {
 				return nil, err
 			}
-			f := p.Functions[node.Token.stringValue]
-
-			// pop off function parameters
-			for i := 0; i < f.Params; i++ {
-				childNode, childErr := stack.pop()
-				if childErr != nil {
-					return nil, childErr
-				}
-				// prepend children so they get added in the right order
-				node.Children = append([]*ParseNode{childNode}, node.Children...)
-			}
-
-			if !checkChildType(node.Children) {
-				return nil, errors.New("Cannot have literal and function/operator mismatch")
+			node.Children = append(node.Children, values...)
+		} else {
+			nextPrecedence := o.Precedence + 1
+			if o.Association == opAssociationRight {
+				nextPrecedence = o.Precedence
 			}
-			stack.push(node)
-		} else if _, ok := p.Operators[stack.peek().Token.stringValue]; ok {
-			// if the top of the stack is an operator
-			node, err := stack.pop()
+			right, err := p.parseExpression(r, nextPrecedence)
 			if err != nil {
 				return nil, err
 			}
-			o := p.Operators[node.Token.stringValue]
-
-			// pop off operands
-			for i := 0; i < o.Operands; i++ {
-				// prepend children so they get added in the right order
-				childNode, childErr := stack.pop()
-				if childErr != nil {
-					return nil, childErr
-				}
-				node.Children = append([]*ParseNode{childNode}, node.Children...)
-			}
-			if !checkChildType(node.Children) {
-				return nil, errors.New("Cannot have literal and function/operator mismatch")
-			}
-			stack.push(node)
+			node.Children = append(node.Children, right)
 		}
-	}
-
-	return currNode, nil
-}
-
-// checkChildType Checks to make sure children types are compatible
-//nolint :gocyclo
-func checkChildType(child []*ParseNode) bool {
-	// Make sure we have 2 children in the tree
-	if len(child) != 2 {
-		return false
-	}
 
-	// Make sure that the token struct exists
-	for _, c := range child {
-		if c.Token == nil {
-			return false
+		if err := checkOperands(node, o); err != nil {
+			return nil, err
 		}
+		left = node
 	}
-
-	// Chrildren with the same type are compatible
-	if child[0].Token.Type == child[1].Token.Type {
-		return true
-	}
-	// If the first child is not an operator and function and
-	// the second child is we have an invalid combination
-	if (child[0].Token.Type != filterTokenLogical &&
-		child[0].Token.Type != filterTokenFunc) &&
-		(child[1].Token.Type == filterTokenLogical ||
-			child[1].Token.Type == filterTokenFunc) {
-		return false
-	}
-	// If the second child is not an operator and function and
-	// the first child is we have an invalid combination
-	if (child[0].Token.Type == filterTokenLogical ||
-		child[0].Token.Type == filterTokenFunc) &&
-		(child[1].Token.Type != filterTokenLogical &&
-			child[1].Token.Type != filterTokenFunc) {
-		return false
-	}
-	return true
-}
-
-type tokenStack struct {
-	Head *tokenStackNode
-	Size int
 }
 
-type tokenStackNode struct {
-	Token *Token
-	Prev  *tokenStackNode
-}
-
-func (s *tokenStack) push(t *Token) {
-	node := tokenStackNode{t, s.Head}
-	s.Head = &node
-	s.Size++
-}
+// parsePrimary parses a literal, a parenthesized expression, a function call or a unary operator
+func (p *Parser) parsePrimary(r *tokenReader) (*ParseNode, error) {
+	token := r.next()
+	if token == nil {
+		return nil, errUnexpectedEnd
+	}
 
-func (s *tokenStack) pop() *Token {
-	node := s.Head
-	s.Head = node.Prev
-	s.Size--
-	return node.Token
-}
+	switch token.Type {
+	case filterTokenOpenParen:
+		node, err := p.parseExpression(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.expect(filterTokenCloseParen, ")"); err != nil {
+			return nil, err
+		}
+		return node, nil
 
-func (s *tokenStack) peek() *Token {
-	return s.Head.Token
-}
+	case filterTokenFunc:
+		return p.parseFunction(r, token)
 
-func (s *tokenStack) empty() bool {
-	return s.Head == nil
-}
+	case filterTokenLogical:
+		o := p.Operators[token.stringValue]
+		if o.Operands != 1 {
+			return nil, unexpectedToken(token)
+		}
+		operand, err := p.parseExpression(r, o.Precedence)
+		if err != nil {
+			return nil, err
+		}
+		node := &ParseNode{token, nil, []*ParseNode{operand}}
+		if err := checkOperands(node, o); err != nil {
+			return nil, err
+		}
+		return node, nil
 
-type tokenQueue struct {
-	Head *tokenQueueNode
-	Tail *tokenQueueNode
-}
+	case filterTokenCloseParen, filterTokenComma:
+		return nil, unexpectedToken(token)
+	}
 
-type tokenQueueNode struct {
-	Token *Token
-	Prev  *tokenQueueNode
-	Next  *tokenQueueNode
+	return &ParseNode{token, nil, make([]*ParseNode, 0)}, nil
 }
 
-func (q *tokenQueue) enqueue(t *Token) {
-	node := tokenQueueNode{t, q.Tail, nil}
-	//fmt.Println(t.Value)
-
-	if q.Tail == nil {
-		q.Head = &node
-	} else {
-		q.Tail.Next = &node
+// parseFunction parses the parenthesized parameters of a function call
+func (p *Parser) parseFunction(r *tokenReader, token *Token) (*ParseNode, error) {
+	if _, err := r.expect(filterTokenOpenParen, "("); err != nil {
+		return nil, err
+	}
+	params, err := p.parseArguments(r)
+	if err != nil {
+		return nil, err
 	}
 
-	q.Tail = &node
+	f := p.Functions[token.stringValue]
+	if len(params) != f.Params {
+		return nil, fmt.Errorf("parse error: function '%s' at position %d expects %d parameters but got %d",
+			token.stringValue, token.pos, f.Params, len(params))
+	}
+	for _, param := range params {
+		if !isValue(param) {
+			return nil, fmt.Errorf("parse error: function '%s' at position %d cannot have '%s' as parameter",
+				token.stringValue, token.pos, param.Token.stringValue)
+		}
+	}
+	return &ParseNode{token, nil, params}, nil
 }
 
-func (q *tokenQueue) dequeue() *Token {
-	node := q.Head
-	if node.Next != nil {
-		node.Next.Prev = nil
+// parseList parses the parenthesized list of values on the right side of a variadic operator
+func (p *Parser) parseList(r *tokenReader) ([]*ParseNode, error) {
+	open, err := r.expect(filterTokenOpenParen, "(")
+	if err != nil {
+		return nil, err
 	}
-	q.Head = node.Next
-	if q.Head == nil {
-		q.Tail = nil
+	values, err := p.parseArguments(r)
+	if err != nil {
+		return nil, err
 	}
-	return node.Token
+	if len(values) == 0 {
+		return nil, fmt.Errorf("parse error: empty list at position %d", open.pos)
+	}
+	return values, nil
 }
 
-func (q *tokenQueue) empty() bool {
-	return q.Head == nil && q.Tail == nil
-}
+// parseArguments parses comma separated expressions up to and including the closing parenthesis
+func (p *Parser) parseArguments(r *tokenReader) ([]*ParseNode, error) {
+	args := make([]*ParseNode, 0)
+	if token := r.peek(); token != nil && token.Type == filterTokenCloseParen {
+		r.next()
+		return args, nil
+	}
 
-type nodeStack struct {
-	Head *nodeStackNode
-}
+	for {
+		arg, err := p.parseExpression(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
 
-type nodeStackNode struct {
-	ParseNode *ParseNode
-	Prev      *nodeStackNode
+		token := r.next()
+		if token == nil {
+			return nil, fmt.Errorf("parse error: expected ')' but reached the end of filter")
+		}
+		switch token.Type {
+		case filterTokenComma:
+			continue
+		case filterTokenCloseParen:
+			return args, nil
+		}
+		return nil, fmt.Errorf("parse error: expected ',' or ')' but found '%s' at position %d",
+			token.stringValue, token.pos)
+	}
 }
 
-func (s *nodeStack) push(n *ParseNode) {
-	node := nodeStackNode{n, s.Head}
-	s.Head = &node
+// checkOperands Checks that logical operators combine boolean expressions and that
+// other operators compare values
+func checkOperands(node *ParseNode, o *Operator) error {
+	for _, child := range node.Children {
+		if o.Predicates && !isPredicate(child) {
+			return fmt.Errorf("parse error: operator '%s' at position %d requires boolean operands but got '%s'",
+				node.Token.stringValue, node.Token.pos, child.Token.stringValue)
+		}
+		if !o.Predicates && !isValue(child) {
+			return fmt.Errorf("parse error: operator '%s' at position %d cannot compare '%s'",
+				node.Token.stringValue, node.Token.pos, child.Token.stringValue)
+		}
+	}
+	return nil
 }
 
-func (s *nodeStack) pop() (*ParseNode, error) {
-	node := s.Head
-	if node == nil {
-		return nil, errors.New("Child node not available")
-	}
-	s.Head = node.Prev
-	return node.ParseNode, nil
+// isPredicate reports whether the node evaluates to a boolean
+func isPredicate(node *ParseNode) bool {
+	return node.Token.Type == filterTokenLogical ||
+		node.Token.Type == filterTokenFunc ||
+		node.Token.Type == filterTokenBoolean
 }
 
-func (s *nodeStack) peek() *ParseNode {
-	return s.Head.ParseNode
+// isValue reports whether the node is a literal value or property
+func isValue(node *ParseNode) bool {
+	return node.Token.Type != filterTokenLogical && node.Token.Type != filterTokenFunc
 }
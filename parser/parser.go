@@ -52,7 +52,7 @@ func ParseURLValues(query url.Values) (map[string]interface{}, error) {
 
 		switch queryParam {
 		case Select:
-			parseResult, err = parseStringArray(&value)
+			parseResult, err = parseSelectArray(&value)
 		case Top:
 			parseResult, err = parseInt(&value)
 		case Skip:
@@ -259,6 +259,14 @@ func TestParseSelectWithSpace(t *testing.T) {
 	}
 }
 
+func TestParseSelectInvalidFields(t *testing.T) {
+	for _, input := range []string{"name,,age", "name,", "name,age,name", "name,*", "*,name"} {
+		if _, err := ParseURLValues(url.Values{Select: {input}}); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
 func TestParseWithInvalidIntValues(t *testing.T) {
 	testURL, err := url.Parse("http://localhost/test?$top=top")
 	if err != nil {
@@ -441,3 +449,69 @@ func TestParseWithFilter(t *testing.T) {
 // 		printTree(v, level+1)
 // 	}
 // }
+
+func TestParseFilterTree(t *testing.T) {
+	var treeTests = []struct {
+		input    string
+		expected string
+	}{
+		{"a eq 1 or b eq 2 and c eq 3", "(or (eq a 1) (and (eq b 2) (eq c 3)))"},
+		{"(a eq 1 or b eq 2) and c eq 3", "(and (or (eq a 1) (eq b 2)) (eq c 3))"},
+		{"a eq 1 and b eq 2 and c eq 3", "(and (and (eq a 1) (eq b 2)) (eq c 3))"},
+		{"not contains(name, 'x') and a gt 1", "(and (not (contains name 'x')) (gt a 1))"},
+		{"not (a eq 1 or b eq 2)", "(not (or (eq a 1) (eq b 2)))"},
+		{"status in ('a', 'b', 'c') or qty ge 2.5", "(or (in status 'a' 'b' 'c') (ge qty 2.5))"},
+		{"a eq(1)", "(eq a 1)"},
+	}
+
+	for _, test := range treeTests {
+		tree, err := parseFilterString(test.input)
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		if result := treeString(tree); result != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, result)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	var errorTests = []struct {
+		input    string
+		expected string
+	}{
+		{"a eq 1, b eq 2", "parse error: unexpected ',' at position 6"},
+		{"contains(a, 'b'", "parse error: expected ')' but reached the end of filter"},
+		{"contains(a)", "parse error: function 'contains' at position 0 expects 2 parameters but got 1"},
+		{"a eq 1 and b", "parse error: operator 'and' at position 7 requires boolean operands but got 'b'"},
+		{"(a eq 1 b)", "parse error: expected ')' but found 'b' at position 8"},
+		{"a eq", "parse error: unexpected end of filter"},
+		{"status in ()", "parse error: empty list at position 10"},
+		{"not a", "parse error: operator 'not' at position 0 requires boolean operands but got 'a'"},
+		{"a", "parse error: filter must be a boolean expression"},
+	}
+
+	for _, test := range errorTests {
+		_, err := parseFilterString(test.input)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("%s: expected error %q, got %q", test.input, test.expected, err.Error())
+		}
+	}
+}
+
+// treeString prints the tree as an s-expression
+func treeString(n *ParseNode) string {
+	if len(n.Children) == 0 {
+		return n.Token.stringValue
+	}
+	result := "(" + n.Token.stringValue
+	for _, child := range n.Children {
+		result += " " + treeString(child)
+	}
+	return result + ")"
+}
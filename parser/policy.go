@@ -0,0 +1,78 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDenied is returned when a policy does not allow a query
+var ErrDenied = errors.New("odata query denied by policy")
+
+// Policy implements row-level security for an entity set
+type Policy struct {
+	// Filter returns an additional filter the query of the principal is constrained with,
+	// or nil when no constraint is needed. Returning an error (e.g. ErrDenied) denies the query.
+	Filter func(principal interface{}, query map[string]interface{}) (*ParseNode, error)
+	// Fields which cannot be used in $filter, so hidden values cannot be inferred through predicates.
+	// Their parents and nested fields cannot be used either.
+	Unfilterable []string
+	// Fields which cannot be used in $orderby, nor can their parents and nested fields
+	Unsortable []string
+}
+
+// Apply checks the fields used by the query and constrains it with the policy filter.
+// Errors caused by forbidden fields have ErrDenied as cause. The query is modified in place.
+func (p *Policy) Apply(principal interface{}, query map[string]interface{}) error {
+	if filter, ok := query[Filter].(*ParseNode); ok && filter != nil {
+		if field, found := findProperty(filter, p.Unfilterable); found {
+			return errors.Wrapf(ErrDenied, "filtering on field '%s' is not allowed", field)
+		}
+	}
+	if orderBy, ok := query[OrderBy].([]OrderItem); ok {
+		for _, item := range orderBy {
+			if matchesField(item.Field, p.Unsortable) {
+				return errors.Wrapf(ErrDenied, "ordering on field '%s' is not allowed", item.Field)
+			}
+		}
+	}
+
+	if p.Filter == nil {
+		return nil
+	}
+	filter, err := p.Filter(principal, query)
+	if err != nil {
+		return err
+	}
+	return ApplyScope(query, filter)
+}
+
+// findProperty returns the first property of the tree matching one of the fields
+func findProperty(node *ParseNode, fields []string) (string, bool) {
+	if node.Token != nil && node.Token.Type == filterTokenLiteral && matchesField(node.Token.stringValue, fields) {
+		return node.Token.stringValue, true
+	}
+	for _, child := range node.Children {
+		if field, found := findProperty(child, fields); found {
+			return field, true
+		}
+	}
+	return "", false
+}
+
+// matchesField reports whether the field is one of the fields, is nested in one of them or
+// is a parent of one of them: a predicate on a parent also applies to its nested fields
+func matchesField(field string, fields []string) bool {
+	for _, f := range fields {
+		if field == f || strings.HasPrefix(field, f+".") || strings.HasPrefix(f, field+".") {
+			return true
+		}
+	}
+	return false
+}
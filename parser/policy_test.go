@@ -0,0 +1,77 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+var testPolicy = &Policy{
+	Filter: func(principal interface{}, query map[string]interface{}) (*ParseNode, error) {
+		switch principal {
+		case "admin":
+			return nil, nil
+		case "guest":
+			return nil, ErrDenied
+		}
+		return Eq(Prop("owner"), String(principal.(string))), nil
+	},
+	Unfilterable: []string{"cost", "supplier.notes"},
+	Unsortable:   []string{"supplier"},
+}
+
+func TestPolicyApply(t *testing.T) {
+	var policyTests = []struct {
+		principal string
+		query     url.Values
+		expected  string
+	}{
+		{"bob", url.Values{Filter: {"qty gt 1"}}, "(and (eq owner 'bob') (gt qty 1))"},
+		{"bob", url.Values{Top: {"1"}}, "(eq owner 'bob')"},
+		{"admin", url.Values{Filter: {"qty gt 1"}}, "(gt qty 1)"},
+	}
+
+	for _, test := range policyTests {
+		query, err := ParseURLValues(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testPolicy.Apply(test.principal, query); err != nil {
+			t.Fatal(err)
+		}
+		if result := treeString(query[Filter].(*ParseNode)); result != test.expected {
+			t.Errorf("Expected: %s \tGot: %s", test.expected, result)
+		}
+	}
+}
+
+func TestPolicyDenied(t *testing.T) {
+	var deniedTests = []struct {
+		principal string
+		query     url.Values
+	}{
+		{"guest", url.Values{Top: {"1"}}},
+		{"admin", url.Values{Filter: {"qty gt 1 and not (cost lt 10)"}}},
+		{"admin", url.Values{Filter: {"startswith(cost.currency, 'E')"}}},
+		{"admin", url.Values{Filter: {"contains(supplier, 'secret')"}}},
+		{"admin", url.Values{OrderBy: {"supplier.name"}}},
+		{"admin", url.Values{OrderBy: {"qty,supplier desc"}}},
+	}
+
+	for _, test := range deniedTests {
+		query, err := ParseURLValues(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testPolicy.Apply(test.principal, query); errors.Cause(err) != ErrDenied {
+			t.Errorf("%v: expected query to be denied, got %v", test.query, err)
+		}
+	}
+}
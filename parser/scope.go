@@ -0,0 +1,31 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"errors"
+)
+
+// ApplyScope constrains a parsed query with a server enforced filter, e.g. tenantId eq 'X'.
+// The scope and the $filter sent by the client are joined by an and at the root of the tree,
+// so nothing in the client filter can widen the scope. The query is modified in place.
+func ApplyScope(query map[string]interface{}, scope *ParseNode) error {
+	if scope == nil {
+		return nil
+	}
+	if scope.Token == nil || !isPredicate(scope) {
+		return errors.New("scope must be a boolean expression")
+	}
+
+	userFilter, _ := query[Filter].(*ParseNode)
+	if userFilter == nil {
+		query[Filter] = scope.Clone()
+		return nil
+	}
+	query[Filter] = And(scope.Clone(), userFilter)
+	return nil
+}
@@ -0,0 +1,52 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package parser
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestApplyScope(t *testing.T) {
+	scope := Eq(Prop("tenantId"), String("X"))
+
+	var scopeTests = []struct {
+		query    url.Values
+		expected string
+	}{
+		{url.Values{Filter: {"a eq 1 or tenantId eq 'Y'"}}, "(and (eq tenantId 'X') (or (eq a 1) (eq tenantId 'Y')))"},
+		{url.Values{Filter: {"not (tenantId eq 'X')"}}, "(and (eq tenantId 'X') (not (eq tenantId 'X')))"},
+		{url.Values{Top: {"10"}}, "(eq tenantId 'X')"},
+	}
+
+	for _, test := range scopeTests {
+		query, err := ParseURLValues(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyScope(query, scope); err != nil {
+			t.Fatal(err)
+		}
+		if result := treeString(query[Filter].(*ParseNode)); result != test.expected {
+			t.Errorf("Expected: %s \tGot: %s", test.expected, result)
+		}
+	}
+
+	query, _ := ParseURLValues(url.Values{Top: {"10"}})
+	ApplyScope(query, scope)
+	query[Filter].(*ParseNode).Children[1].Token.Value = "changed"
+	if scope.Children[1].Token.Value != "'X'" {
+		t.Error("scope was modified through the query")
+	}
+}
+
+func TestApplyScopeInvalid(t *testing.T) {
+	query, _ := ParseURLValues(url.Values{Filter: {"a eq 1"}})
+	if err := ApplyScope(query, Prop("tenantId")); err == nil {
+		t.Error("Failed to catch error")
+	}
+}
@@ -0,0 +1,244 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package postgresql
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-go-odata/parser"
+	"github.com/pkg/errors"
+)
+
+// Option configures how odata queries are parsed and translated
+type Option func(*options)
+
+type options struct {
+	cache     *parser.Cache
+	scope     *parser.ParseNode
+	policy    *parser.Policy
+	principal interface{}
+	fields    *parser.FieldAccess
+	types     map[string]FieldType
+	columns   map[string]string
+	key       string
+
+	caseInsensitive bool
+	useContainment  bool
+}
+
+// FieldType is the SQL type the values of a jsonb field are compared as
+type FieldType string
+
+// Field types
+const (
+	TextField      FieldType = "text"
+	NumericField   FieldType = "numeric"
+	BooleanField   FieldType = "boolean"
+	DateField      FieldType = "date"
+	TimeField      FieldType = "time"
+	TimestampField FieldType = "timestamptz"
+
+	// jsonbField compares the jsonb values, e.g. numbers numerically
+	jsonbField FieldType = "jsonb"
+	// columnField compares a column with values of its own type
+	columnField FieldType = ""
+)
+
+// WithCache parses the odata queries through the given cache
+func WithCache(cache *parser.Cache) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
+// WithScope constrains every query with a server enforced filter, e.g. tenantId eq 'X'.
+// The client $filter is joined with an and below the scope so it cannot widen it.
+func WithScope(scope *parser.ParseNode) Option {
+	return func(o *options) {
+		o.scope = scope
+	}
+}
+
+// WithPolicy applies the row-level security policy of the entity set for the principal
+// making the request. Queries denied by the policy fail with its error.
+func WithPolicy(policy *parser.Policy, principal interface{}) Option {
+	return func(o *options) {
+		o.policy = policy
+		o.principal = principal
+	}
+}
+
+// WithFieldAccess hides fields from the principal making the request. Hidden fields are
+// rejected or dropped from $select, excluded from the default projection and cannot be
+// used in $filter or $orderby.
+func WithFieldAccess(access *parser.FieldAccess) Option {
+	return func(o *options) {
+		o.fields = access
+	}
+}
+
+// WithFieldTypes declares the types of jsonb fields. The fields are cast to their type,
+// and the literals they are compared with as well, and are sorted by their type. Other
+// fields are compared by the type of the literal: numbers and booleans as jsonb, dates
+// and times as date, time and timestamptz, and strings as text. They are sorted as text.
+func WithFieldTypes(types map[string]FieldType) Option {
+	return func(o *options) {
+		o.types = types
+	}
+}
+
+// WithColumns maps odata properties to the table columns storing them, e.g. for tables
+// mixing columns and a jsonb document. When the jsonb column is empty, the table is
+// relational and the other properties are columns of the same name. A relational table
+// with hidden fields returns the visible columns of the map when $select is absent.
+func WithColumns(columns map[string]string) Option {
+	return func(o *options) {
+		o.columns = columns
+	}
+}
+
+// WithKeyColumn sets the key column returned with the selected fields, id by default
+func WithKeyColumn(key string) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+// CaseInsensitive makes contains, startswith and endswith ignore case with ILIKE,
+// like the default of the mongo adapter. By default they match the case of the value.
+func CaseInsensitive() Option {
+	return func(o *options) {
+		o.caseInsensitive = true
+	}
+}
+
+// JSONBContainment translates equalities on jsonb fields into containment, e.g.
+// "data" @> '{"sku":"123"}', which a single GIN index on the jsonb column serves, and
+// numeric ranges into jsonpath predicates with @? (PostgreSQL 12). GIN indexes cannot
+// serve the range predicates. Containment compares the jsonb types: sku eq '123' no longer
+// matches the number 123. not of an in or of a range also matches documents without the
+// field, while ne, which not (x eq ...) is rewritten into, does not.
+func JSONBContainment() Option {
+	return func(o *options) {
+		o.useContainment = true
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{key: "id"}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// parse parses the url values, using the cache when one is configured,
+// and applies the field access, the policy and the server enforced scope.
+// Syntax errors are wrapped in ErrInvalidInput.
+func (o *options) parse(query url.Values) (map[string]interface{}, error) {
+	var queryMap map[string]interface{}
+	var err error
+	if o.cache != nil {
+		queryMap, err = o.cache.ParseURLValues(query)
+	} else {
+		queryMap, err = parser.ParseURLValues(query)
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidInput, err.Error())
+	}
+
+	if o.fields != nil {
+		if err := o.fields.Apply(queryMap); err != nil {
+			return nil, err
+		}
+	}
+	if o.policy != nil {
+		if err := o.policy.Apply(o.principal, queryMap); err != nil {
+			return nil, err
+		}
+	}
+	if err := parser.ApplyScope(queryMap, o.scope); err != nil {
+		return nil, err
+	}
+	return queryMap, nil
+}
+
+// hidden returns the fields hidden from the principal
+func (o *options) hidden() []string {
+	if o.fields == nil {
+		return nil
+	}
+	return o.fields.Hidden
+}
+
+// columnOf returns the column storing the property, or false when the property
+// is a field of the jsonb column
+func (o *options) columnOf(property string, column string) (string, bool) {
+	if name, ok := o.columns[property]; ok {
+		return name, true
+	}
+	if column == "" {
+		return property, true
+	}
+	return "", false
+}
+
+// visibleColumns returns the sorted columns of the properties which are not hidden
+func (o *options) visibleColumns() []string {
+	properties := make([]string, 0, len(o.columns))
+	for property := range o.columns {
+		if o.fields == nil || !o.fields.IsHidden(property) {
+			properties = append(properties, property)
+		}
+	}
+	sort.Strings(properties)
+
+	columns := make([]string, len(properties))
+	for i, property := range properties {
+		columns[i] = o.columns[property]
+	}
+	return columns
+}
+
+// comparisonType returns the type the field is compared with the values as:
+// the declared type of the field, or the type shared by the literals
+func (o *options) comparisonType(field string, values []*parser.ParseNode) FieldType {
+	if fieldType, ok := o.types[field]; ok && validFieldTypes[fieldType] {
+		return fieldType
+	}
+
+	comparisonType := literalFieldTypes[values[0].LiteralType()]
+	for _, value := range values[1:] {
+		if literalFieldTypes[value.LiteralType()] != comparisonType {
+			return TextField
+		}
+	}
+	if comparisonType == "" {
+		return TextField
+	}
+	return comparisonType
+}
+
+var validFieldTypes = map[FieldType]bool{
+	TextField:      true,
+	NumericField:   true,
+	BooleanField:   true,
+	DateField:      true,
+	TimeField:      true,
+	TimestampField: true,
+}
+
+// Types the fields are compared as by literal type
+var literalFieldTypes = map[string]FieldType{
+	"int":      jsonbField,
+	"float":    jsonbField,
+	"bool":     jsonbField,
+	"date":     DateField,
+	"time":     TimeField,
+	"datetime": TimestampField,
+}
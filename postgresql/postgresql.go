@@ -7,11 +7,14 @@
 package postgresql
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/intel/rsp-sw-toolkit-im-suite-go-odata/parser"
 	"github.com/lib/pq"
@@ -30,62 +33,163 @@ var sqlOperators = map[string]string{
 	"le":         "<=",
 	"or":         "or",
 	"and":        "and",
+	"not":        "NOT",
+	"in":         "IN",
 	"contains":   "%%%s%%",
 	"endswith":   "%%%s",
 	"startswith": "%s%%",
 }
 
-// ODataSQLQuery builds a SQL like query based on OData 2.0 specification
-func ODataSQLQuery(query url.Values, table string, column string, db *sql.DB) (*sql.Rows, error) {
+// Name of the column holding the inline count in the rows of ODataSQLQueryResult
+const inlineCountColumn = "odata_inline_count"
+
+// Result holds the rows of an odata query along with the inline count
+type Result struct {
+	// Items holds the selected columns of every row by column name,
+	// json and jsonb columns are returned as json.RawMessage
+	Items []map[string]interface{}
+	// InlineCount is the number of rows matching the filter, ignoring $top and $skip
+	InlineCount int
+	// HasInlineCount reports whether $inlinecount=allpages was requested
+	HasInlineCount bool
+}
+
+// Querier runs the queries of the adapter, it is satisfied by *sql.DB, *sql.Tx and *sql.Conn
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ODataSQLQuery builds a SQL like query based on OData 2.0 specification and runs it
+func ODataSQLQuery(query url.Values, table string, column string, db *sql.DB, opts ...Option) (*sql.Rows, error) {
+	return ODataSQLQueryContext(context.Background(), query, table, column, db, opts...)
+}
+
+// ODataSQLQueryContext runs the query of ODataSQLQuery with the querier, e.g. in a transaction.
+// The query is cancelled when the context is done.
+func ODataSQLQueryContext(ctx context.Context, query url.Values, table string, column string, db Querier, opts ...Option) (*sql.Rows, error) {
+	finalQuery, args, err := BuildSQLQuery(query, table, column, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, finalQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// ODataSQLQueryResult runs the query of ODataSQLQuery and reads the rows. When
+// $inlinecount=allpages is set, the count is computed by the same statement with a
+// window function, a second statement is only needed when the page is empty.
+func ODataSQLQueryResult(query url.Values, table string, column string, db *sql.DB, opts ...Option) (*Result, error) {
+	return ODataSQLQueryResultContext(context.Background(), query, table, column, db, opts...)
+}
+
+// ODataSQLQueryResultContext runs ODataSQLQueryResult with the querier, e.g. in a transaction.
+// The queries are cancelled when the context is done.
+func ODataSQLQueryResultContext(ctx context.Context, query url.Values, table string, column string, db Querier, opts ...Option) (*Result, error) {
+
+	// Parse url values
+	o := newOptions(opts)
+	queryMap, err := o.parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{HasInlineCount: queryMap[parser.InlineCount] == "allpages"}
+	finalQuery, args, err := buildSQLQuery(queryMap, table, column, o, result.HasInlineCount)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, finalQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if result.Items, result.InlineCount, err = scanRows(rows); err != nil {
+		return nil, err
+	}
+
+	if result.HasInlineCount && len(result.Items) == 0 {
+		// the window function has no row to return the count with, e.g. past the last page
+		countQuery, countArgs, err := buildCountQuery(queryMap, table, column, o)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.QueryRowContext(ctx, countQuery, countArgs...).Scan(&result.InlineCount); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// BuildSQLQuery builds the SQL query of ODataSQLQuery without running it. The literals of
+// the query are passed as arguments of $1..$n placeholders, so queries of the same shape
+// share their SQL text and plan.
+func BuildSQLQuery(query url.Values, table string, column string, opts ...Option) (string, []interface{}, error) {
 
 	// Parse url values
-	queryMap, err := parser.ParseURLValues(query)
+	o := newOptions(opts)
+	queryMap, err := o.parse(query)
 	if err != nil {
-		return nil, errors.Wrap(ErrInvalidInput, err.Error())
+		return "", nil, err
 	}
+	return buildSQLQuery(queryMap, table, column, o, false)
+}
+
+// buildSQLQuery builds the SQL query, with the count of the matching rows in every row when inlineCount is set
+func buildSQLQuery(queryMap map[string]interface{}, table string, column string, o *options, inlineCount bool) (string, []interface{}, error) {
 
 	var finalQuery strings.Builder
+	var args sqlArgs
 
 	// SELECT clause
-	finalQuery.WriteString(buildSelectClause(queryMap, column))
+	selectClause, err := buildSelectClause(queryMap, column, o)
+	if err != nil {
+		return "", nil, err
+	}
+	finalQuery.WriteString(selectClause)
+	if inlineCount {
+		fmt.Fprintf(&finalQuery, ", count(*) OVER() AS %s", pq.QuoteIdentifier(inlineCountColumn))
+	}
 
 	// FROM clause
 	finalQuery.WriteString(" FROM ")
-	finalQuery.WriteString(pq.QuoteIdentifier(table))
+	finalQuery.WriteString(quoteTable(table))
 
 	// WHERE clause
-	if queryMap[parser.Filter] != nil {
-		finalQuery.WriteString(" WHERE ")
-		filterQuery, _ := queryMap[parser.Filter].(*parser.ParseNode)
-		filterClause, err := applyFilter(filterQuery, column)
-		if err != nil {
-			return nil, errors.Wrap(ErrInvalidInput, err.Error())
-		}
-
-		finalQuery.WriteString(filterClause)
+	whereClause, err := buildWhereClause(queryMap, column, &args, o)
+	if err != nil {
+		return "", nil, err
 	}
+	finalQuery.WriteString(whereClause)
 
 	// Order by
 	if queryMap[parser.OrderBy] != nil {
-		finalQuery.WriteString(buildOrderBy(queryMap, column))
+		finalQuery.WriteString(buildOrderBy(queryMap, column, o))
 	}
 
 	// Limit & Offset
-	finalQuery.WriteString(buildLimitSkipClause(queryMap))
-
-	rows, err := db.Query(finalQuery.String())
-	if err != nil {
-		return nil, err
-	}
-	return rows, nil
+	finalQuery.WriteString(buildLimitSkipClause(queryMap, &args))
 
+	return finalQuery.String(), args, nil
 }
 
-// ODataCount returns the number of rows from a table
+// ODataCount returns the number of rows from a table, ignoring any filter.
+// Use ODataFilteredCount for $count requests with a $filter.
 func ODataCount(db *sql.DB, table string) (int, error) {
+	return ODataCountContext(context.Background(), db, table)
+}
+
+// ODataCountContext runs ODataCount with the querier, cancelling the query when the context is done
+func ODataCountContext(ctx context.Context, db Querier, table string) (int, error) {
 	var count int
-	selectStmt := fmt.Sprintf("SELECT count(*) FROM %s", pq.QuoteIdentifier(table))
-	row := db.QueryRow(selectStmt)
+	selectStmt := fmt.Sprintf("SELECT count(*) FROM %s", quoteTable(table))
+	row := db.QueryRowContext(ctx, selectStmt)
 	err := row.Scan(&count)
 	if err != nil {
 		return 0, err
@@ -93,32 +197,156 @@ func ODataCount(db *sql.DB, table string) (int, error) {
 	return count, nil
 }
 
-func buildSelectClause(queryMap map[string]interface{}, column string) string {
+// ODataFilteredCount returns the number of rows matching the $filter of the query,
+// constrained by the scope and policy options. $top and $skip are ignored.
+func ODataFilteredCount(query url.Values, table string, column string, db *sql.DB, opts ...Option) (int, error) {
+	return ODataFilteredCountContext(context.Background(), query, table, column, db, opts...)
+}
+
+// ODataFilteredCountContext runs ODataFilteredCount with the querier, cancelling the query
+// when the context is done
+func ODataFilteredCountContext(ctx context.Context, query url.Values, table string, column string, db Querier, opts ...Option) (int, error) {
+
+	// Parse url values
+	o := newOptions(opts)
+	queryMap, err := o.parse(query)
+	if err != nil {
+		return 0, err
+	}
+
+	countQuery, args, err := buildCountQuery(queryMap, table, column, o)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, countQuery, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ODataInlineCount returns the number of rows matching the $filter of the query,
+// to be sent along the rows of ODataSQLQuery when $inlinecount=allpages is set
+func ODataInlineCount(query url.Values, table string, column string, db *sql.DB, opts ...Option) (int, error) {
+	return ODataFilteredCount(query, table, column, db, opts...)
+}
+
+// scanRows reads the columns of the rows into maps, taking the inline count out of them
+func scanRows(rows *sql.Rows) ([]map[string]interface{}, int, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items := make([]map[string]interface{}, 0)
+	inlineCount := 0
+	for rows.Next() {
+		values := make([]interface{}, len(columnTypes))
+		pointers := make([]interface{}, len(columnTypes))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, 0, err
+		}
+
+		item := make(map[string]interface{}, len(columnTypes))
+		for i, columnType := range columnTypes {
+			switch value := values[i].(type) {
+			case int64:
+				if columnType.Name() == inlineCountColumn {
+					inlineCount = int(value)
+					continue
+				}
+			case []byte:
+				// the driver reuses its buffer
+				data := append([]byte(nil), value...)
+				if typeName := columnType.DatabaseTypeName(); typeName == "JSONB" || typeName == "JSON" {
+					values[i] = json.RawMessage(data)
+				} else {
+					values[i] = data
+				}
+			}
+			item[columnType.Name()] = values[i]
+		}
+		items = append(items, item)
+	}
+	return items, inlineCount, rows.Err()
+}
+
+func buildCountQuery(queryMap map[string]interface{}, table string, column string, o *options) (string, []interface{}, error) {
+	var args sqlArgs
+	whereClause, err := buildWhereClause(queryMap, column, &args, o)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("SELECT count(*) FROM %s%s", quoteTable(table), whereClause), args, nil
+}
+
+func buildWhereClause(queryMap map[string]interface{}, column string, args *sqlArgs, o *options) (string, error) {
+	filterQuery, _ := queryMap[parser.Filter].(*parser.ParseNode)
+	if filterQuery == nil {
+		return "", nil
+	}
+	filterClause, err := whereClause(filterQuery, column, args, o)
+	if err != nil {
+		return "", err
+	}
+	return " WHERE " + filterClause, nil
+}
+
+func buildSelectClause(queryMap map[string]interface{}, column string, o *options) (string, error) {
 
 	// Select clause
 	// 'data' is the column name of the jsonb data
 	selectSlice, _ := queryMap["$select"].([]string)
-	if len(selectSlice) == 0 {
-		return "SELECT * "
-	}
-
-	var selectClause strings.Builder
-	selectClause.WriteString("SELECT id,jsonb_build_object(")
+	hidden := o.hidden()
+	columns := []string{pq.QuoteIdentifier(o.key)}
 	col := pq.QuoteIdentifier(column)
 
-	for _, fieldName := range selectSlice[:len(selectSlice)-1] {
-		fmt.Fprintf(&selectClause, "%s, %s -> %s,",
-			pq.QuoteLiteral(fieldName), col, pq.QuoteLiteral(fieldName))
+	if len(selectSlice) == 0 || selectSlice[0] == "*" {
+		if len(hidden) == 0 {
+			return "SELECT * ", nil
+		}
+		// the columns of a relational table are only known from the column map
+		if column == "" && len(o.columns) == 0 {
+			return "", errors.Wrap(ErrInvalidInput, "hiding fields of a relational table requires its columns, see WithColumns")
+		}
+		// only the visible columns are known
+		for _, name := range o.visibleColumns() {
+			columns = append(columns, pq.QuoteIdentifier(name))
+		}
+		if column != "" {
+			// remove the hidden fields from the documents
+			var document strings.Builder
+			document.WriteString(col)
+			for _, fieldName := range hidden {
+				if _, ok := o.columnOf(fieldName, column); !ok {
+					fmt.Fprintf(&document, " #- %s", jsonPath(fieldName))
+				}
+			}
+			fmt.Fprintf(&document, " AS %s", col)
+			columns = append(columns, document.String())
+		}
+		return "SELECT " + strings.Join(columns, ","), nil
 	}
 
-	// last one without a comma
-	fieldName := selectSlice[len(selectSlice)-1]
-	fmt.Fprintf(&selectClause, "%s, %s -> %s ) AS %s", pq.QuoteLiteral(fieldName), col, pq.QuoteLiteral(fieldName), col)
-
-	return selectClause.String()
+	var fields []string
+	for _, fieldName := range selectSlice {
+		if name, ok := o.columnOf(fieldName, column); !ok {
+			fields = append(fields, fmt.Sprintf("%s, %s", pq.QuoteLiteral(fieldName), jsonField(column, fieldName, false)))
+		} else if name != o.key {
+			columns = append(columns, pq.QuoteIdentifier(name))
+		}
+	}
+	if len(fields) > 0 {
+		columns = append(columns, fmt.Sprintf("jsonb_build_object(%s ) AS %s", strings.Join(fields, ","), col))
+	}
+	return "SELECT " + strings.Join(columns, ","), nil
 }
 
-func buildLimitSkipClause(queryMap map[string]interface{}) string {
+func buildLimitSkipClause(queryMap map[string]interface{}, args *sqlArgs) string {
 
 	limit, existLimit := queryMap[parser.Top].(int)
 	skip, existSkip := queryMap[parser.Skip].(int)
@@ -127,28 +355,34 @@ func buildLimitSkipClause(queryMap map[string]interface{}) string {
 
 	if existLimit {
 		queryString.WriteString(" LIMIT ")
-		queryString.WriteString(strconv.Itoa(limit))
+		queryString.WriteString(args.add(limit))
 	}
 
 	if existSkip {
 		queryString.WriteString(" OFFSET ")
-		queryString.WriteString(strconv.Itoa(skip))
+		queryString.WriteString(args.add(skip))
 	}
 
 	return queryString.String()
 
 }
 
-func buildOrderBy(queryMap map[string]interface{}, column string) string {
+func buildOrderBy(queryMap map[string]interface{}, column string, o *options) string {
 
 	var query strings.Builder
 	query.WriteString(" ORDER BY ")
 
-	col := pq.QuoteIdentifier(column)
 	orderBySlice := queryMap[parser.OrderBy].([]parser.OrderItem)
 
 	for id, item := range orderBySlice {
-		fmt.Fprintf(&query, "%s ->> %s", col, pq.QuoteLiteral(item.Field))
+		if name, ok := o.columnOf(item.Field, column); ok {
+			query.WriteString(pq.QuoteIdentifier(name))
+		} else if fieldType, ok := o.types[item.Field]; ok && validFieldTypes[fieldType] {
+			// sort declared fields as they are compared, e.g. numbers numerically
+			query.WriteString(fieldExpression(column, item.Field, fieldType))
+		} else {
+			query.WriteString(jsonField(column, item.Field, true))
+		}
 		if item.Order == "desc" {
 			query.WriteString(" DESC ")
 		}
@@ -161,72 +395,341 @@ func buildOrderBy(queryMap map[string]interface{}, column string) string {
 	return query.String()
 }
 
-func applyFilter(node *parser.ParseNode, column string) (string, error) {
+// WhereClause translates a filter tree, parsed from $filter or built with the
+// parser package, into a SQL condition on the jsonb column, or on the columns of a
+// relational table when column is empty. The literals are returned as the arguments
+// of the $1..$n placeholders of the condition.
+func WhereClause(node *parser.ParseNode, column string, opts ...Option) (string, []interface{}, error) {
+	var args sqlArgs
+	filter, err := whereClause(node, column, &args, newOptions(opts))
+	if err != nil {
+		return "", nil, err
+	}
+	return filter, args, nil
+}
 
-	if len(node.Children) != 2 {
-		return "", ErrInvalidInput
+func whereClause(node *parser.ParseNode, column string, args *sqlArgs, o *options) (string, error) {
+	if err := parser.Validate(node); err != nil {
+		return "", errors.Wrap(ErrInvalidInput, err.Error())
 	}
+	filter, err := applyFilter(parser.Normalize(node), column, args, o)
+	if err != nil {
+		return "", errors.Wrap(ErrInvalidInput, err.Error())
+	}
+	return filter, nil
+}
 
-	var filter strings.Builder
+func applyFilter(node *parser.ParseNode, column string, args *sqlArgs, o *options) (string, error) {
 
-	operator := node.Token.Value.(string)
+	// filters folded into a constant match everything or nothing
+	if value, ok := node.Token.Value.(bool); ok && len(node.Children) == 0 {
+		return strings.ToUpper(strconv.FormatBool(value)), nil
+	}
+
+	operator, _ := node.Token.Value.(string)
 	sqlOp := sqlOperators[operator]
 	if operator == "" || sqlOp == "" {
 		// invalid or unknown operator
 		return "", ErrInvalidInput
 	}
 
+	if !validOperands(operator, len(node.Children)) {
+		return "", ErrInvalidInput
+	}
+
+	var filter strings.Builder
+
 	switch operator {
 
 	case "eq", "ne", "gt", "ge", "lt", "le":
 
-		if _, keyOk := node.Children[0].Token.Value.(string); !keyOk {
+		field, keyOk := node.Children[0].Token.Value.(string)
+		if !keyOk {
 			return "", ErrInvalidInput
 		}
 
-		left := pq.QuoteLiteral(node.Children[0].Token.Value.(string))
-
-		if value, valueOk := node.Children[1].Token.Value.(string); valueOk {
-			node.Children[1].Token.Value = escapeQuote(value)
+		if condition, ok := o.containment(column, field, operator, node.Children[1:], args); ok {
+			filter.WriteString(condition)
+			break
 		}
 
-		right := pq.QuoteLiteral(fmt.Sprintf("%v", node.Children[1].Token.Value))
+		left, fieldType := o.operand(column, field, node.Children[1:])
+		right := args.addTyped(node.Children[1], fieldType)
 
-		fmt.Fprintf(&filter, "%s ->> %s %s %s", pq.QuoteIdentifier(column), left, sqlOp, right)
+		fmt.Fprintf(&filter, "%s %s %s", left, sqlOp, right)
 
 	case "or", "and":
 
-		leftFilter, err := applyFilter(node.Children[0], column) // Left children
-		if err != nil {
-			return "", err
+		// normalized trees join any number of children
+		filters := make([]string, 0, len(node.Children))
+		for _, child := range node.Children {
+			childFilter, err := applyFilter(child, column, args, o)
+			if err != nil {
+				return "", err
+			}
+			// keep the grouping of the tree, or binds weaker than and in SQL
+			if child.Token.Value == "and" || child.Token.Value == "or" {
+				childFilter = "(" + childFilter + ")"
+			}
+			filters = append(filters, childFilter)
 		}
-		rightFilter, err := applyFilter(node.Children[1], column) // Right children
+		filter.WriteString(strings.Join(filters, " "+operator+" "))
+
+	case "not":
+		subFilter, err := applyFilter(node.Children[0], column, args, o)
 		if err != nil {
 			return "", err
 		}
-		fmt.Fprintf(&filter, "%s %s %s", leftFilter, operator, rightFilter)
+		fmt.Fprintf(&filter, "%s (%s)", sqlOp, subFilter)
+
+	case "in":
+		field, keyOk := node.Children[0].Token.Value.(string)
+		if !keyOk {
+			return "", ErrInvalidInput
+		}
+
+		if condition, ok := o.containment(column, field, operator, node.Children[1:], args); ok {
+			filter.WriteString(condition)
+			break
+		}
+
+		left, fieldType := o.operand(column, field, node.Children[1:])
+		values := make([]string, 0, len(node.Children)-1)
+		for _, child := range node.Children[1:] {
+			values = append(values, args.addTyped(child, fieldType))
+		}
+
+		fmt.Fprintf(&filter, "%s %s (%s)", left, sqlOp, strings.Join(values, ", "))
 
 	//Functions
 	case "contains", "endswith", "startswith":
 		if _, ok := node.Children[1].Token.Value.(string); !ok {
 			return "", ErrInvalidInput
 		}
-		// Remove single quote
-		value, valueOk := node.Children[1].Token.Value.(string)
-		if !valueOk {
+		field, ok := node.Children[0].Token.Value.(string)
+		if !ok {
 			return "", ErrInvalidInput
 		}
-		node.Children[1].Token.Value = escapeQuote(value)
 
-		left := pq.QuoteLiteral(node.Children[0].Token.Value.(string))
-		right := pq.QuoteLiteral(fmt.Sprintf(sqlOp, node.Children[1].Token.Value.(string)))
+		left, _ := o.operand(column, field, nil)
+		right := args.add(fmt.Sprintf(sqlOp, escapeLike(escapeQuote(node.Children[1].Token.Value.(string)))))
 
-		fmt.Fprintf(&filter, "%s ->> %s LIKE %s", pq.QuoteIdentifier(column), left, right)
+		likeOp := "LIKE"
+		if o.caseInsensitive {
+			likeOp = "ILIKE"
+		}
+		fmt.Fprintf(&filter, "%s %s %s ESCAPE '\\'", left, likeOp, right)
 	}
 
 	return filter.String(), nil
 }
 
+// sqlArgs collects the arguments of the $n placeholders of a query
+type sqlArgs []interface{}
+
+// add appends the argument and returns its placeholder
+func (a *sqlArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// addTyped appends the literal as an argument of the field type and returns its placeholder
+func (a *sqlArgs) addTyped(literal *parser.ParseNode, fieldType FieldType) string {
+	placeholder := a.add(sqlValue(literal, fieldType))
+	if fieldType == TextField || fieldType == columnField {
+		return placeholder
+	}
+	return placeholder + "::" + string(fieldType)
+}
+
+// sqlValue converts a literal into the argument compared with a field of the given type
+func sqlValue(literal *parser.ParseNode, fieldType FieldType) interface{} {
+	switch v := literal.Token.Value.(type) {
+	case string:
+		return escapeQuote(v)
+	case time.Time:
+		if fieldType == columnField {
+			fieldType = literalFieldTypes[literal.LiteralType()]
+		}
+		switch fieldType {
+		case DateField:
+			return v.Format("2006-01-02")
+		case TimeField:
+			return v.Format("15:04:05.999999999")
+		case TimestampField:
+			return v
+		}
+	}
+	value := literal.Token.Value
+	switch fieldType {
+	case columnField:
+		return value
+	case jsonbField:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// operand returns the SQL expression of the field and the type the values are compared as
+func (o *options) operand(column string, field string, values []*parser.ParseNode) (string, FieldType) {
+	if name, ok := o.columnOf(field, column); ok {
+		return pq.QuoteIdentifier(name), columnField
+	}
+	if len(values) == 0 {
+		return fieldExpression(column, field, TextField), TextField
+	}
+	fieldType := o.comparisonType(field, values)
+	return fieldExpression(column, field, fieldType), fieldType
+}
+
+// fieldExpression returns the SQL expression of the jsonb field compared as the given type
+func fieldExpression(column string, field string, fieldType FieldType) string {
+	switch fieldType {
+	case TextField:
+		return jsonField(column, field, true)
+	case jsonbField:
+		return jsonField(column, field, false)
+	}
+	return fmt.Sprintf("(%s)::%s", jsonField(column, field, true), fieldType)
+}
+
+// jsonField returns the jsonb field, as text or as jsonb. A dotted field name is the
+// path of a nested field, e.g. "data" #>> '{"tag","epc"}' for tag.epc
+func jsonField(column string, field string, text bool) string {
+	col := pq.QuoteIdentifier(column)
+	if strings.Contains(field, ".") {
+		if text {
+			return fmt.Sprintf("%s #>> %s", col, jsonPath(field))
+		}
+		return fmt.Sprintf("%s #> %s", col, jsonPath(field))
+	}
+	if text {
+		return fmt.Sprintf("%s ->> %s", col, pq.QuoteLiteral(field))
+	}
+	return fmt.Sprintf("%s -> %s", col, pq.QuoteLiteral(field))
+}
+
+// containment translates equalities into jsonb containment, which a GIN index on the jsonb
+// column can serve, and numeric ranges into jsonpath predicates. It only applies to fields of
+// the jsonb column without a declared type, compared with strings, numbers or booleans.
+func (o *options) containment(column string, field string, operator string, values []*parser.ParseNode, args *sqlArgs) (string, bool) {
+	if !o.useContainment {
+		return "", false
+	}
+	if _, ok := o.columnOf(field, column); ok {
+		return "", false
+	}
+	if _, ok := o.types[field]; ok {
+		return "", false
+	}
+	col := pq.QuoteIdentifier(column)
+
+	switch operator {
+	case "eq", "in":
+		documents := make([]string, len(values))
+		for i, value := range values {
+			document, ok := containedDocument(field, value)
+			if !ok {
+				return "", false
+			}
+			documents[i] = document
+		}
+		conditions := make([]string, len(documents))
+		for i, document := range documents {
+			conditions[i] = fmt.Sprintf("%s @> %s::jsonb", col, args.add(document))
+		}
+		if len(conditions) == 1 {
+			return conditions[0], true
+		}
+		return "(" + strings.Join(conditions, " or ") + ")", true
+
+	case "gt", "ge", "lt", "le":
+		literalType := values[0].LiteralType()
+		if literalType != "int" && literalType != "float" {
+			return "", false
+		}
+		number, err := json.Marshal(values[0].Token.Value)
+		if err != nil {
+			return "", false
+		}
+		path := fmt.Sprintf("%s ? (@ %s %s)", jsonpathOf(field), sqlOperators[operator], number)
+		return fmt.Sprintf("%s @? %s::jsonpath", col, args.add(path)), true
+	}
+	return "", false
+}
+
+// containedDocument returns the JSON document holding the literal at the path of the field,
+// e.g. {"tag":{"epc":"123"}} for tag.epc eq '123'
+func containedDocument(field string, literal *parser.ParseNode) (string, bool) {
+	var value interface{}
+	switch literal.LiteralType() {
+	case "string":
+		value = escapeQuote(literal.Token.Value.(string))
+	case "int", "float", "bool":
+		value = literal.Token.Value
+	default:
+		return "", false
+	}
+
+	parts := strings.Split(field, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		value = map[string]interface{}{parts[i]: value}
+	}
+	document, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(document), true
+}
+
+// jsonpathOf converts a dotted field name into a jsonpath, e.g. $."tag"."epc"
+func jsonpathOf(field string) string {
+	var path strings.Builder
+	path.WriteString("$")
+	for _, part := range strings.Split(field, ".") {
+		quoted, _ := json.Marshal(part)
+		path.WriteString(".")
+		path.Write(quoted)
+	}
+	return path.String()
+}
+
+// quoteTable quotes a table name, optionally qualified with its schema, e.g. "inventory"."items"
+func quoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// jsonPath converts a dotted field name into a quoted text array path, e.g. '{"a","b"}'
+func jsonPath(field string) string {
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(part) + `"`
+	}
+	return pq.QuoteLiteral("{" + strings.Join(parts, ",") + "}")
+}
+
+// validOperands checks the number of children of an operator node
+func validOperands(operator string, count int) bool {
+	switch operator {
+	case "not":
+		return count == 1
+	case "in", "and", "or":
+		return count >= 2
+	}
+	return count == 2
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, so the value is matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
 func escapeQuote(value string) string {
 
 	if len(value) <= 1 {
@@ -240,5 +743,5 @@ func escapeQuote(value string) string {
 		value = value[:len(value)-1]
 	}
 
-	return value
+	return strings.Replace(value, "''", "'", -1)
 }
@@ -7,11 +7,16 @@
 package postgresql
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/intel/rsp-sw-toolkit-im-suite-go-odata/parser"
 	_ "github.com/lib/pq" // postgreSQL driver
 	"github.com/pkg/errors"
 )
@@ -105,6 +110,618 @@ func TestCount(t *testing.T) {
 
 }
 
+func TestFilteredCount(t *testing.T) {
+
+	db := dbSetup()
+
+	testURL, err := url.Parse("http://localhost/test?$filter=age gt 10&$top=1")
+	if err != nil {
+		t.Error("failed to parse test url")
+	}
+
+	_, errorQuery := ODataFilteredCount(testURL.Query(), "test", "data", db)
+
+	if errorQuery != nil {
+		t.Error(errorQuery)
+	}
+
+}
+
+func TestCountQueryAppliesFilterAndScope(t *testing.T) {
+
+	var countTests = []struct {
+		input    url.Values
+		expected string
+		args     []interface{}
+	}{
+		{url.Values{parser.Top: {"1"}},
+			`SELECT count(*) FROM "test" WHERE "data" ->> 'tenantId' = $1`, []interface{}{"X"}},
+		{url.Values{parser.Filter: {"age gt 10"}, parser.Skip: {"5"}},
+			`SELECT count(*) FROM "test" WHERE "data" ->> 'tenantId' = $1 and "data" -> 'age' > $2::jsonb`, []interface{}{"X", "10"}},
+	}
+
+	scope := WithScope(parser.Eq(parser.Prop("tenantId"), parser.String("X")))
+	for _, test := range countTests {
+		o := newOptions([]Option{scope})
+		queryMap, err := o.parse(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		countQuery, args, err := buildCountQuery(queryMap, "test", "data", o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if countQuery != test.expected || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Expected: %s %v \tGot: %s %v", test.expected, test.args, countQuery, args)
+		}
+	}
+}
+
+func TestWhereClauseFromBuilder(t *testing.T) {
+
+	filter := parser.And(parser.Eq(parser.Prop("status"), parser.String("active")),
+		parser.Gt(parser.Prop("qty"), parser.Int(0)))
+
+	clause, args, err := WhereClause(filter, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `"data" ->> 'status' = $1 and "data" -> 'qty' > $2::jsonb`
+	expectedArgs := []interface{}{"active", "0"}
+	if clause != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected: %s %v \tGot: %s %v", expected, expectedArgs, clause, args)
+	}
+}
+
+func TestWhereClauseRejectsInvalidTree(t *testing.T) {
+
+	invalid := []*parser.ParseNode{
+		parser.And(),
+		parser.Or(),
+		parser.Eq(parser.Prop("a"), parser.And(parser.Eq(parser.Prop("b"), parser.Int(1)))),
+	}
+
+	for _, node := range invalid {
+		if _, _, err := WhereClause(node, "data"); errors.Cause(err) != ErrInvalidInput {
+			t.Errorf("Expected ErrInvalidInput for %v, got: %v", node, err)
+		}
+	}
+}
+
+func TestScopeWrapsUserFilter(t *testing.T) {
+
+	scope := WithScope(parser.Eq(parser.Prop("tenantId"), parser.String("X")))
+	queryMap, err := newOptions([]Option{scope}).parse(url.Values{parser.Filter: {"a eq 1 or tenantId eq 'Y'"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clause, args, err := WhereClause(queryMap[parser.Filter].(*parser.ParseNode), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `"data" ->> 'tenantId' = $1 and ("data" -> 'a' = $2::jsonb or "data" ->> 'tenantId' = $3)`
+	expectedArgs := []interface{}{"X", "1", "Y"}
+	if clause != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected: %s %v \tGot: %s %v", expected, expectedArgs, clause, args)
+	}
+}
+
+func TestBuildSQLQuery(t *testing.T) {
+
+	query := url.Values{
+		parser.Filter:  {"name in ('a', 'it''s') and startswith(code, 'x')"},
+		parser.OrderBy: {"name desc"},
+		parser.Top:     {"10"},
+		parser.Skip:    {"20"},
+	}
+
+	sqlQuery, args, err := BuildSQLQuery(query, "test", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT *  FROM "test" WHERE "data" ->> 'name' IN ($1, $2) and "data" ->> 'code' LIKE $3 ESCAPE '\'` +
+		` ORDER BY "data" ->> 'name' DESC  LIMIT $4 OFFSET $5`
+	expectedArgs := []interface{}{"a", "it's", "x%", 10, 20}
+	if sqlQuery != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected: %s %v \tGot: %s %v", expected, expectedArgs, sqlQuery, args)
+	}
+
+	// * selects the whole document and cannot be mixed with other fields
+	if _, _, err := BuildSQLQuery(url.Values{parser.Select: {"name,*"}}, "test", "data"); errors.Cause(err) != ErrInvalidInput {
+		t.Errorf("Expected ErrInvalidInput, got: %v", err)
+	}
+}
+
+func TestTypedComparisons(t *testing.T) {
+
+	var comparisonTests = []struct {
+		filter   string
+		expected string
+		args     []interface{}
+	}{
+		{"age gt 10", `"data" -> 'age' > $1::jsonb`, []interface{}{"10"}},
+		{"price le 2.5", `"data" -> 'price' <= $1::jsonb`, []interface{}{"2.5"}},
+		{"active eq true", `"data" -> 'active' = $1::jsonb`, []interface{}{"true"}},
+		{"age in (1, 2.5)", `"data" -> 'age' IN ($1::jsonb, $2::jsonb)`, []interface{}{"1", "2.5"}},
+		{"age in (1, 'a')", `"data" ->> 'age' IN ($1, $2)`, []interface{}{"1", "a"}},
+		{"day ge 2019-08-01 and day lt 2019-09-01",
+			`("data" ->> 'day')::date >= $1::date and ("data" ->> 'day')::date < $2::date`,
+			[]interface{}{"2019-08-01", "2019-09-01"}},
+		{"at gt 10:30", `("data" ->> 'at')::time > $1::time`, []interface{}{"10:30:00"}},
+		{"created ge 2019-08-01T10:30:00Z", `("data" ->> 'created')::timestamptz >= $1::timestamptz`,
+			[]interface{}{time.Date(2019, 8, 1, 10, 30, 0, 0, time.UTC)}},
+		{"sku gt '10'", `("data" ->> 'sku')::numeric > $1::numeric`, []interface{}{"10"}},
+		{"name eq 'it''s'", `"data" ->> 'name' = $1`, []interface{}{"it's"}},
+	}
+
+	types := WithFieldTypes(map[string]FieldType{"sku": NumericField})
+	for _, test := range comparisonTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {test.filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clause, args, err := WhereClause(queryMap[parser.Filter].(*parser.ParseNode), "data", types)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clause != test.expected || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: Expected: %s %v \tGot: %s %v", test.filter, test.expected, test.args, clause, args)
+		}
+	}
+}
+
+func TestTypedOrdering(t *testing.T) {
+
+	query := url.Values{
+		parser.Filter:  {"qty gt 5"},
+		parser.OrderBy: {"qty desc,day,name"},
+	}
+	types := WithFieldTypes(map[string]FieldType{"qty": NumericField, "day": DateField})
+
+	sqlQuery, _, err := BuildSQLQuery(query, "items", "data", types)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT *  FROM "items" WHERE ("data" ->> 'qty')::numeric > $1::numeric` +
+		` ORDER BY ("data" ->> 'qty')::numeric DESC ,("data" ->> 'day')::date,"data" ->> 'name'`
+	if sqlQuery != expected {
+		t.Errorf("Expected: %s \tGot: %s", expected, sqlQuery)
+	}
+}
+
+func TestNumericOrderingAndDateRange(t *testing.T) {
+
+	db := dbSetup()
+
+	const schema = `
+			CREATE TABLE IF NOT EXISTS typed_test (
+				id int,
+				data JSONB
+			);
+			TRUNCATE typed_test;
+			INSERT INTO typed_test VALUES
+				(1, '{"age": 9, "day": "2019-07-31"}'),
+				(2, '{"age": 10, "day": "2019-08-01"}'),
+				(3, '{"age": 100, "day": "2019-08-31"}'),
+				(4, '{"age": 2.5, "day": "2019-09-01"}');
+	`
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var countTests = []struct {
+		filter   string
+		expected int
+	}{
+		{"age gt 9", 2},
+		{"age lt 10", 2},
+		{"age ge 2.5 and age le 10", 3},
+		{"day ge 2019-08-01 and day lt 2019-09-01", 2},
+	}
+
+	for _, test := range countTests {
+		count, err := ODataFilteredCount(url.Values{parser.Filter: {test.filter}}, "typed_test", "data", db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != test.expected {
+			t.Errorf("%s: Expected %d rows, got %d", test.filter, test.expected, count)
+		}
+	}
+}
+
+func TestLogicalGrouping(t *testing.T) {
+
+	var groupingTests = []struct {
+		filter   string
+		expected string
+	}{
+		{"(a eq 'x' or b eq 'y') and c eq 'z'",
+			`("data" ->> 'a' = $1 or "data" ->> 'b' = $2) and "data" ->> 'c' = $3`},
+		{"a eq 'x' or (b eq 'y' and c eq 'z')",
+			`"data" ->> 'a' = $1 or ("data" ->> 'b' = $2 and "data" ->> 'c' = $3)`},
+		{"(a eq 'x' or b eq 'y') and (c eq 'z' or (d eq 'w' and a ne 'v'))",
+			`("data" ->> 'a' = $1 or "data" ->> 'b' = $2) and ("data" ->> 'c' = $3 or ("data" ->> 'd' = $4 and "data" ->> 'a' != $5))`},
+		{"not (a eq 'x' and b eq 'y') and c eq 'z'",
+			`("data" ->> 'a' != $1 or "data" ->> 'b' != $2) and "data" ->> 'c' = $3`},
+	}
+
+	for _, test := range groupingTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {test.filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clause, _, err := WhereClause(queryMap[parser.Filter].(*parser.ParseNode), "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clause != test.expected {
+			t.Errorf("%s: Expected: %s \tGot: %s", test.filter, test.expected, clause)
+		}
+	}
+}
+
+func TestLogicalGroupingResults(t *testing.T) {
+
+	db := dbSetup()
+
+	const schema = `
+			CREATE TABLE IF NOT EXISTS grouping_test (
+				id int,
+				data JSONB
+			);
+			TRUNCATE grouping_test;
+			INSERT INTO grouping_test VALUES
+				(1, '{"a": "x", "b": "n", "c": "n"}'),
+				(2, '{"a": "n", "b": "y", "c": "z"}'),
+				(3, '{"a": "x", "b": "n", "c": "z"}'),
+				(4, '{"a": "n", "b": "n", "c": "z"}');
+	`
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var countTests = []struct {
+		filter   string
+		expected int
+	}{
+		{"(a eq 'x' or b eq 'y') and c eq 'z'", 2},
+		{"a eq 'x' or (b eq 'y' and c eq 'z')", 3},
+		{"a eq 'x' or b eq 'y' and c eq 'z'", 3},
+		{"not (a eq 'x' or b eq 'y') and c eq 'z'", 1},
+	}
+
+	for _, test := range countTests {
+		count, err := ODataFilteredCount(url.Values{parser.Filter: {test.filter}}, "grouping_test", "data", db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != test.expected {
+			t.Errorf("%s: Expected %d rows, got %d", test.filter, test.expected, count)
+		}
+	}
+}
+
+func TestRelationalTable(t *testing.T) {
+
+	query := url.Values{
+		parser.Filter:  {"age gt 10 and (startswith(name, 'a') or day in (2019-08-01, 2019-08-02))"},
+		parser.Select:  {"name,age,sku"},
+		parser.OrderBy: {"age desc,name"},
+		parser.Top:     {"10"},
+	}
+
+	sqlQuery, args, err := BuildSQLQuery(query, "inventory.items", "",
+		WithKeyColumn("item_id"), WithColumns(map[string]string{"sku": "item_sku"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT "item_id","name","age","item_sku" FROM "inventory"."items"` +
+		` WHERE "age" > $1 and ("name" LIKE $2 ESCAPE '\' or "day" IN ($3, $4))` +
+		` ORDER BY "age" DESC ,"name" LIMIT $5`
+	expectedArgs := []interface{}{10, "a%", "2019-08-01", "2019-08-02", 10}
+	if sqlQuery != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected: %s %v \tGot: %s %v", expected, expectedArgs, sqlQuery, args)
+	}
+}
+
+func TestRelationalTableHiddenFields(t *testing.T) {
+
+	access := WithFieldAccess(&parser.FieldAccess{Hidden: []string{"cost"}})
+
+	// the columns to return cannot be listed without the column map
+	if _, _, err := BuildSQLQuery(url.Values{}, "items", "", access); errors.Cause(err) != ErrInvalidInput {
+		t.Errorf("Expected ErrInvalidInput, got: %v", err)
+	}
+
+	columns := WithColumns(map[string]string{"name": "name", "cost": "cost", "sku": "item_sku"})
+	sqlQuery, _, err := BuildSQLQuery(url.Values{}, "items", "", columns, access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `SELECT "id","name","item_sku" FROM "items"`
+	if sqlQuery != expected {
+		t.Errorf("Expected: %s \tGot: %s", expected, sqlQuery)
+	}
+
+	sqlQuery, _, err = BuildSQLQuery(url.Values{parser.Select: {"name"}}, "items", "", access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `SELECT "id","name" FROM "items"`
+	if sqlQuery != expected {
+		t.Errorf("Expected: %s \tGot: %s", expected, sqlQuery)
+	}
+}
+
+func TestMixedTable(t *testing.T) {
+
+	columns := WithColumns(map[string]string{"sku": "sku", "cost": "cost", "tenantId": "tenant_id"})
+	access := WithFieldAccess(&parser.FieldAccess{Hidden: []string{"cost", "supplier.notes"}, Drop: true})
+
+	var mixedTests = []struct {
+		input    url.Values
+		expected string
+		args     []interface{}
+	}{
+		{url.Values{parser.Filter: {"sku eq '1' and qty gt 2"}},
+			`SELECT "id","sku","tenant_id","data" #- '{"supplier","notes"}' AS "data" FROM "items"` +
+				` WHERE "sku" = $1 and "data" -> 'qty' > $2::jsonb`,
+			[]interface{}{"1", "2"}},
+		{url.Values{parser.Select: {"sku,name,cost"}, parser.OrderBy: {"sku"}},
+			`SELECT "id","sku",jsonb_build_object('name', "data" -> 'name' ) AS "data" FROM "items" ORDER BY "sku"`,
+			nil},
+	}
+
+	for _, test := range mixedTests {
+		sqlQuery, args, err := BuildSQLQuery(test.input, "items", "data", columns, access)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sqlQuery != test.expected || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Expected: %s %v \tGot: %s %v", test.expected, test.args, sqlQuery, args)
+		}
+	}
+}
+
+func TestInlineCountQuery(t *testing.T) {
+
+	o := newOptions(nil)
+	queryMap, err := o.parse(url.Values{parser.Filter: {"age gt 10"}, parser.Top: {"5"}, parser.InlineCount: {"allpages"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlQuery, args, err := buildSQLQuery(queryMap, "test", "data", o, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `SELECT * , count(*) OVER() AS "odata_inline_count" FROM "test" WHERE "data" -> 'age' > $1::jsonb LIMIT $2`
+	expectedArgs := []interface{}{"10", 5}
+	if sqlQuery != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected: %s %v \tGot: %s %v", expected, expectedArgs, sqlQuery, args)
+	}
+}
+
+func TestODataSQLQueryResult(t *testing.T) {
+
+	db := dbSetup()
+
+	const schema = `
+			CREATE TABLE IF NOT EXISTS result_test (
+				id int,
+				data JSONB
+			);
+			TRUNCATE result_test;
+			INSERT INTO result_test VALUES
+				(1, '{"age": 5}'),
+				(2, '{"age": 15}'),
+				(3, '{"age": 25}'),
+				(4, '{"age": 35}');
+	`
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var resultTests = []struct {
+		input       url.Values
+		items       int
+		inlineCount int
+	}{
+		{url.Values{parser.Filter: {"age gt 10"}, parser.Top: {"2"}, parser.InlineCount: {"allpages"}}, 2, 3},
+		{url.Values{parser.Filter: {"age gt 10"}, parser.Skip: {"10"}, parser.InlineCount: {"allpages"}}, 0, 3},
+		{url.Values{parser.Filter: {"age gt 10"}}, 3, 0},
+	}
+
+	for _, test := range resultTests {
+		result, err := ODataSQLQueryResult(test.input, "result_test", "data", db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Items) != test.items || result.InlineCount != test.inlineCount {
+			t.Errorf("%v: Expected %d items and inline count %d, got %d and %d",
+				test.input, test.items, test.inlineCount, len(result.Items), result.InlineCount)
+		}
+		for _, item := range result.Items {
+			if _, ok := item["data"].(json.RawMessage); !ok {
+				t.Errorf("Expected the jsonb column as json.RawMessage, got %T", item["data"])
+			}
+			if _, ok := item[inlineCountColumn]; ok {
+				t.Error("Expected the inline count to be removed from the items")
+			}
+		}
+	}
+}
+
+// the querier is satisfied by databases, transactions and connections
+var (
+	_ Querier = (*sql.DB)(nil)
+	_ Querier = (*sql.Tx)(nil)
+	_ Querier = (*sql.Conn)(nil)
+)
+
+func TestCancelledContextStopsQuery(t *testing.T) {
+
+	db := dbSetup()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	query := url.Values{parser.Filter: {"age gt 10"}, parser.InlineCount: {"allpages"}}
+	if _, err := ODataSQLQueryContext(ctx, query, "test", "data", db); err != context.Canceled {
+		t.Errorf("Expected the query to be cancelled, got %v", err)
+	}
+	if _, err := ODataSQLQueryResultContext(ctx, query, "test", "data", db); err != context.Canceled {
+		t.Errorf("Expected the query to be cancelled, got %v", err)
+	}
+	if _, err := ODataFilteredCountContext(ctx, query, "test", "data", db); err != context.Canceled {
+		t.Errorf("Expected the count to be cancelled, got %v", err)
+	}
+	if _, err := ODataCountContext(ctx, db, "test"); err != context.Canceled {
+		t.Errorf("Expected the count to be cancelled, got %v", err)
+	}
+}
+
+func TestLikePatterns(t *testing.T) {
+
+	var likeTests = []struct {
+		filter   string
+		opts     []Option
+		expected string
+		args     []interface{}
+	}{
+		{"contains(code, '_')", nil, `"data" ->> 'code' LIKE $1 ESCAPE '\'`, []interface{}{`%\_%`}},
+		{"startswith(code, '50%')", nil, `"data" ->> 'code' LIKE $1 ESCAPE '\'`, []interface{}{`50\%%`}},
+		{`endswith(path, 'a\b')`, nil, `"data" ->> 'path' LIKE $1 ESCAPE '\'`, []interface{}{`%a\\b`}},
+		{"contains(name, 'Abc')", []Option{CaseInsensitive()}, `"data" ->> 'name' ILIKE $1 ESCAPE '\'`, []interface{}{"%Abc%"}},
+	}
+
+	for _, test := range likeTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {test.filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clause, args, err := WhereClause(queryMap[parser.Filter].(*parser.ParseNode), "data", test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clause != test.expected || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: Expected: %s %v \tGot: %s %v", test.filter, test.expected, test.args, clause, args)
+		}
+	}
+}
+
+func TestJSONBContainment(t *testing.T) {
+
+	var containmentTests = []struct {
+		filter   string
+		expected string
+		args     []interface{}
+	}{
+		{"sku eq '123'", `"data" @> $1::jsonb`, []interface{}{`{"sku":"123"}`}},
+		{"tag.epc eq 'it''s' and active eq true",
+			`"data" @> $1::jsonb and "data" @> $2::jsonb`, []interface{}{`{"tag":{"epc":"it's"}}`, `{"active":true}`}},
+		{"sku in (1, 2.5)", `("data" @> $1::jsonb or "data" @> $2::jsonb)`, []interface{}{`{"sku":1}`, `{"sku":2.5}`}},
+		{"age ge 10 and age lt 20.5", `"data" @? $1::jsonpath and "data" @? $2::jsonpath`,
+			[]interface{}{`$."age" ? (@ >= 10)`, `$."age" ? (@ < 20.5)`}},
+		{"name ne 'a'", `"data" ->> 'name' != $1`, []interface{}{"a"}},
+		{"not (sku eq 1)", `"data" -> 'sku' != $1::jsonb`, []interface{}{"1"}},
+		{"not (sku in (1, 2))", `NOT (("data" @> $1::jsonb or "data" @> $2::jsonb))`, []interface{}{`{"sku":1}`, `{"sku":2}`}},
+		{"not (age gt 5)", `NOT ("data" @? $1::jsonpath)`, []interface{}{`$."age" ? (@ > 5)`}},
+		{"name gt 'a'", `"data" ->> 'name' > $1`, []interface{}{"a"}},
+		{"day eq 2019-08-01", `("data" ->> 'day')::date = $1::date`, []interface{}{"2019-08-01"}},
+		{"qty eq 5", `("data" ->> 'qty')::numeric = $1::numeric`, []interface{}{"5"}},
+		{"tenantId eq 'X'", `"tenant_id" = $1`, []interface{}{"X"}},
+		// dotted properties are nested fields whether containment applies or not
+		{"tag.rssi gt -60", `"data" @? $1::jsonpath`, []interface{}{`$."tag"."rssi" ? (@ > -60)`}},
+		{"tag.epc ne 'a'", `"data" #>> '{"tag","epc"}' != $1`, []interface{}{"a"}},
+		{"startswith(tag.epc, 'a')", `"data" #>> '{"tag","epc"}' LIKE $1 ESCAPE '\'`, []interface{}{"a%"}},
+		{"tag.qty eq 5", `("data" #>> '{"tag","qty"}')::numeric = $1::numeric`, []interface{}{"5"}},
+	}
+
+	opts := []Option{
+		JSONBContainment(),
+		WithFieldTypes(map[string]FieldType{"qty": NumericField, "tag.qty": NumericField}),
+		WithColumns(map[string]string{"tenantId": "tenant_id"}),
+	}
+	for _, test := range containmentTests {
+		queryMap, err := parser.ParseURLValues(url.Values{parser.Filter: {test.filter}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clause, args, err := WhereClause(queryMap[parser.Filter].(*parser.ParseNode), "data", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clause != test.expected || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: Expected: %s %v \tGot: %s %v", test.filter, test.expected, test.args, clause, args)
+		}
+	}
+}
+
+func TestPolicyDeniesQuery(t *testing.T) {
+
+	policy := &parser.Policy{Unfilterable: []string{"cost"}}
+	query := url.Values{parser.Filter: {"cost gt 10"}}
+
+	_, err := ODataSQLQuery(query, "test", "data", nil, WithPolicy(policy, "bob"))
+	if errors.Cause(err) != parser.ErrDenied {
+		t.Errorf("Expected query to be denied, got %v", err)
+	}
+}
+
+func TestSelectClauseExcludesHidden(t *testing.T) {
+
+	var selectTests = []struct {
+		input    url.Values
+		expected string
+	}{
+		{url.Values{}, `SELECT "id","data" #- '{"cost"}' #- '{"supplier","notes"}' AS "data"`},
+		{url.Values{parser.Select: {"name,cost"}}, `SELECT "id",jsonb_build_object('name', "data" -> 'name' ) AS "data"`},
+		{url.Values{parser.Select: {"supplier.name"}}, `SELECT "id",jsonb_build_object('supplier.name', "data" #> '{"supplier","name"}' ) AS "data"`},
+	}
+
+	access := WithFieldAccess(&parser.FieldAccess{Hidden: []string{"cost", "supplier.notes"}, Drop: true})
+	for _, test := range selectTests {
+		o := newOptions([]Option{access})
+		queryMap, err := o.parse(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := buildSelectClause(queryMap, "data", o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != test.expected {
+			t.Errorf("Expected: %s \tGot: %s", test.expected, result)
+		}
+	}
+}
+
+func TestNestedFields(t *testing.T) {
+
+	query := url.Values{
+		parser.Filter:  {"supplier.name eq 'acme' and supplier.rating gt 3"},
+		parser.OrderBy: {"supplier.name desc"},
+	}
+
+	sqlQuery, args, err := BuildSQLQuery(query, "items", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT *  FROM "items" WHERE "data" #>> '{"supplier","name"}' = $1 and "data" #> '{"supplier","rating"}' > $2::jsonb ORDER BY "data" #>> '{"supplier","name"}' DESC `
+	expectedArgs := []interface{}{"acme", "3"}
+	if sqlQuery != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected: %s %v \tGot: %s %v", expected, expectedArgs, sqlQuery, args)
+	}
+}
+
 func dbSetup() *sql.DB {
 
 	const schema = `